@@ -0,0 +1,154 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+)
+
+type AddressTx struct {
+	TxID           []byte
+	BlockHash      []byte
+	Height         int
+	Timestamp      int64
+	Received       int
+	Sent           int
+	Counterparties []TxOutput
+}
+
+func (record AddressTx) Serialize() []byte {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	err := encoder.Encode(record)
+	if err != nil {
+		log.Panic(err)
+	}
+	return buffer.Bytes()
+}
+
+func DeserializeAddressTx(data []byte) AddressTx {
+	var record AddressTx
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(&record)
+	if err != nil {
+		log.Panic(err)
+	}
+	return record
+}
+
+func addrIndexKey(pubKeyHash []byte, height int, position int) []byte {
+	suffix := make([]byte, 12)
+	binary.BigEndian.PutUint64(suffix, uint64(height))
+	binary.BigEndian.PutUint32(suffix[8:], uint32(position))
+	return bytes.Join([][]byte{addrIndexPrefix, pubKeyHash, suffix}, []byte{})
+}
+
+// indexAddresses records, for every address a transaction credits or debits,
+// how much it received and sent. It must run before the UTXO set drops the
+// outputs the block spends, since their values and owners come from there.
+func indexAddresses(batch Batch, block *Block) error {
+	created := make(map[string]TxOutput)
+
+	for position, tx := range block.Transactions {
+		records := make(map[string]*AddressTx)
+		record := func(pubKeyHash []byte) *AddressTx {
+			key := hex.EncodeToString(pubKeyHash)
+			if records[key] == nil {
+				records[key] = &AddressTx{TxID: tx.ID, BlockHash: block.Hash, Height: block.Height, Timestamp: block.CreationTime}
+			}
+			return records[key]
+		}
+
+		if !tx.FlagCoinbaseTx() {
+			for _, in := range tx.Inputs {
+				key := utxoKey(in.ID, in.Out)
+				out, ok := created[string(key)]
+				if !ok {
+					value, err := batch.Get(key)
+					if errors.Is(err, ErrNotFound) {
+						continue
+					}
+					if err != nil {
+						return err
+					}
+					out = DeserializeOutput(value)
+				}
+				record(out.PubKeyHash).Sent += out.Value
+			}
+		}
+		for out_id, out := range tx.Outputs {
+			created[string(utxoKey(tx.ID, out_id))] = out
+			record(out.PubKeyHash).Received += out.Value
+		}
+
+		for key, entry := range records {
+			pubKeyHash, _ := hex.DecodeString(key)
+			for _, out := range tx.Outputs {
+				if !out.IsLockedWithKey(pubKeyHash) {
+					entry.Counterparties = append(entry.Counterparties, out)
+				}
+			}
+			err := batch.Put(addrIndexKey(pubKeyHash, block.Height, position), entry.Serialize())
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// deindexAddresses removes the entries indexAddresses wrote for a block that
+// is being disconnected. The addresses a transaction debited come from the
+// block's undo data, so it must run before the UTXO set is reverted.
+func deindexAddresses(batch Batch, block *Block) error {
+	spent, err := spentOutputs(batch, block)
+	if err != nil {
+		return err
+	}
+
+	i := 0
+	for position, tx := range block.Transactions {
+		var touched []TxOutput
+		if !tx.FlagCoinbaseTx() {
+			if i+len(tx.Inputs) > len(spent) {
+				return fmt.Errorf("Undo data of block %d is too short", block.Height)
+			}
+			touched = append(touched, spent[i:i+len(tx.Inputs)]...)
+			i += len(tx.Inputs)
+		}
+		touched = append(touched, tx.Outputs...)
+
+		for _, out := range touched {
+			err := batch.Delete(addrIndexKey(out.PubKeyHash, block.Height, position))
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// AddressHistory lists the transactions touching an address, most recent
+// first, skipping the first skip entries and returning at most count (all
+// of them when count is zero).
+func (chain *BlockChain) AddressHistory(pubKeyHash []byte, skip int, count int) []AddressTx {
+	var history []AddressTx
+	prefix := append(append([]byte{}, addrIndexPrefix...), pubKeyHash...)
+
+	seen := 0
+	err := chain.Database.Iterate(prefix, true, func(key, value []byte) bool {
+		if seen++; seen <= skip {
+			return true
+		}
+		history = append(history, DeserializeAddressTx(value))
+		return count == 0 || len(history) < count
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return history
+}
@@ -0,0 +1,74 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+
+	"github.com/gustavoddoki/GoBlockchain/wallet"
+)
+
+// AuthorityEngine is a round-robin proof of authority: the block at height h
+// must be signed by Authorities[h % len(Authorities)]. Every block weighs the
+// same, so the longest valid branch is the main chain.
+type AuthorityEngine struct {
+	// Authorities are the public key hashes of the signers, in turn order.
+	Authorities [][]byte
+	// Keys are the private keys this node seals with. A node that only
+	// follows the chain needs none.
+	Keys []ecdsa.PrivateKey
+}
+
+// NextBits is always zero: authority blocks carry no proof of work target.
+func (engine AuthorityEngine) NextBits(store ChainStore, params *ChainParams, parent *Block) (uint32, error) {
+	return 0, nil
+}
+
+func (engine AuthorityEngine) inTurn(height int) ([]byte, error) {
+	if len(engine.Authorities) == 0 {
+		return nil, errors.New("Proof of authority chain has no authorities")
+	}
+	return engine.Authorities[height%len(engine.Authorities)], nil
+}
+
+// Seal signs the block with the key of the authority whose turn it is, if
+// that key is among engine.Keys.
+func (engine AuthorityEngine) Seal(ctx context.Context, block *Block) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	signer, err := engine.inTurn(block.Height)
+	if err != nil {
+		return err
+	}
+
+	for _, key := range engine.Keys {
+		if bytes.Equal(wallet.PublicKeyHash(publicKeyBytes(key)), signer) {
+			return signBlock(block, key)
+		}
+	}
+	return ruleError(ErrBadSeal, "block %d must be signed by %s, whose key is not available",
+		block.Height, wallet.PubKeyHashToAddress(signer))
+}
+
+// VerifyHeader checks that the block was signed by the authority in turn.
+func (engine AuthorityEngine) VerifyHeader(block *Block) error {
+	signer, err := engine.inTurn(block.Height)
+	if err != nil {
+		return err
+	}
+	if err := verifyBlockSignature(block); err != nil {
+		return err
+	}
+	if !bytes.Equal(wallet.PublicKeyHash(block.PubKey), signer) {
+		return ruleError(ErrBadSeal, "block %x is signed by %s, not by %s, whose turn it is",
+			block.Hash, wallet.PubKeyHashToAddress(wallet.PublicKeyHash(block.PubKey)), wallet.PubKeyHashToAddress(signer))
+	}
+	return nil
+}
+
+func (engine AuthorityEngine) Weight(block *Block) *big.Int {
+	return big.NewInt(1)
+}
@@ -0,0 +1,124 @@
+package blockchain
+
+import (
+	"errors"
+	"os"
+
+	"github.com/dgraph-io/badger"
+)
+
+type BadgerStore struct {
+	DB *badger.DB
+}
+
+type badgerBatch struct {
+	txn *badger.Txn
+}
+
+func OpenBadgerStore(path string) (*BadgerStore, error) {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return nil, err
+	}
+
+	opts := badger.DefaultOptions("")
+	opts.Dir = path
+	opts.ValueDir = path
+
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, err
+	}
+	return &BadgerStore{db}, nil
+}
+
+func getFromTxn(txn *badger.Txn, key []byte) ([]byte, error) {
+	item, err := txn.Get(key)
+	if errors.Is(err, badger.ErrKeyNotFound) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return item.ValueCopy(nil)
+}
+
+func (store *BadgerStore) Get(key []byte) ([]byte, error) {
+	var value []byte
+	err := store.DB.View(func(txn *badger.Txn) error {
+		var err error
+		value, err = getFromTxn(txn, key)
+		return err
+	})
+	return value, err
+}
+
+func (store *BadgerStore) GetBlock(hash []byte) (*Block, error) {
+	encoded_block, err := store.Get(blockKey(hash))
+	if err != nil {
+		return nil, err
+	}
+	return DeserializeBlock(encoded_block)
+}
+
+func (store *BadgerStore) GetTip() ([]byte, error) {
+	return store.Get(tipKey)
+}
+
+func (store *BadgerStore) Iterate(prefix []byte, reverse bool, fn func(key, value []byte) bool) error {
+	return store.DB.View(func(txn *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.Reverse = reverse
+		it := txn.NewIterator(opts)
+		defer it.Close()
+
+		start := prefix
+		if reverse {
+			start = append(append([]byte{}, prefix...), 0xff)
+		}
+		for it.Seek(start); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			value, err := item.ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if !fn(item.KeyCopy(nil), value) {
+				break
+			}
+		}
+		return nil
+	})
+}
+
+func (store *BadgerStore) Update(fn func(batch Batch) error) error {
+	return store.DB.Update(func(txn *badger.Txn) error {
+		return fn(&badgerBatch{txn})
+	})
+}
+
+func (store *BadgerStore) Close() error {
+	return store.DB.Close()
+}
+
+func (batch *badgerBatch) Get(key []byte) ([]byte, error) {
+	return getFromTxn(batch.txn, key)
+}
+
+func (batch *badgerBatch) Put(key []byte, value []byte) error {
+	return batch.txn.Set(key, value)
+}
+
+func (batch *badgerBatch) Delete(key []byte) error {
+	return batch.txn.Delete(key)
+}
+
+func (batch *badgerBatch) PutBlock(block *Block) error {
+	return batch.txn.Set(blockKey(block.Hash), block.Serialize())
+}
+
+func (batch *badgerBatch) GetTip() ([]byte, error) {
+	return getFromTxn(batch.txn, tipKey)
+}
+
+func (batch *badgerBatch) SetTip(hash []byte) error {
+	return batch.txn.Set(tipKey, hash)
+}
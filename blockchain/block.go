@@ -5,7 +5,18 @@ import (
 	"crypto/sha256"
 	"encoding/gob"
 	"log"
-	"time"
+)
+
+// Blocks from merkleBlockVersion on commit to their transactions with a
+// Merkle root rather than a hash of the concatenated transaction IDs, and
+// hash and sign transactions with a fixed layout rather than gob. The later
+// versions came with rules on timestamps, the coinbase and its maturity,
+// but a block is held to them because of its height, not its version:
+// blocks below the legacy height of the chain have version 0 and all later
+// ones currentBlockVersion.
+const (
+	merkleBlockVersion  = 1
+	currentBlockVersion = 4
 )
 
 type Block struct {
@@ -14,6 +25,14 @@ type Block struct {
 	PreviousHash []byte
 	CreationTime int64
 	Nonce        int
+	Height       int
+	TxRoot       []byte
+	Bits         uint32
+	Version      int
+	// Signature and PubKey seal blocks of proof of authority chains. They
+	// are not part of the hash, which the signature covers.
+	Signature []byte
+	PubKey    []byte
 }
 
 func (block *Block) HashTransactions() []byte {
@@ -23,23 +42,39 @@ func (block *Block) HashTransactions() []byte {
 	for _, tx := range block.Transactions {
 		tx_hashes = append(tx_hashes, tx.ID)
 	}
+	if block.Version >= merkleBlockVersion {
+		return MerkleRoot(tx_hashes)
+	}
 	tx_hash = sha256.Sum256(bytes.Join(tx_hashes, []byte{}))
 	return tx_hash[:]
 }
 
-func CreateBlock(transactions []*Transaction, previous_hash []byte) *Block {
-	block := &Block{[]byte{}, transactions, previous_hash, time.Now().Unix(), 0}
-	pow := CreateProofOfWork(block)
-	nonce, hash := pow.Run()
+// TransactionsHash is the commitment to the transactions that goes into the
+// proof of work. A pruned block has lost its transactions and keeps only
+// this value, in TxRoot.
+func (block *Block) TransactionsHash() []byte {
+	if len(block.TxRoot) > 0 {
+		return block.TxRoot
+	}
+	return block.HashTransactions()
+}
 
-	block.Hash = hash[:]
-	block.Nonce = nonce
+func (block *Block) IsPruned() bool {
+	return len(block.Transactions) == 0
+}
 
-	return block
+// Header returns a copy of the block without its transactions, keeping
+// their hash in TxRoot so the proof of work can still be checked.
+func (block *Block) Header() *Block {
+	header := *block
+	header.TxRoot = block.TransactionsHash()
+	header.Transactions = nil
+	return &header
 }
 
-func CreateGenesisBlock(coinbase *Transaction) *Block {
-	return CreateBlock([]*Transaction{coinbase}, []byte{})
+// newBlock returns a block that still has to be mined.
+func newBlock(transactions []*Transaction, previous_hash []byte, height int, bits uint32, creationTime int64) *Block {
+	return &Block{[]byte{}, transactions, previous_hash, creationTime, 0, height, nil, bits, currentBlockVersion, nil, nil}
 }
 
 func (block *Block) Serialize() []byte {
@@ -53,11 +88,19 @@ func (block *Block) Serialize() []byte {
 }
 
 func Deserialize(data []byte) *Block {
+	block, err := DeserializeBlock(data)
+	if err != nil {
+		log.Panic(err)
+	}
+	return block
+}
+
+func DeserializeBlock(data []byte) (*Block, error) {
 	var block Block
 	decoder := gob.NewDecoder(bytes.NewReader(data))
 	err := decoder.Decode(&block)
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
-	return &block
+	return &block, nil
 }
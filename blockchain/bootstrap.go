@@ -0,0 +1,231 @@
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// A bootstrap file holds a whole chain: the magic bytes, format version,
+// network magic and consensus engine, then every block from genesis to tip,
+// each one serialized. The engine and every block are preceded by their
+// length as a big-endian uint32. Files of the first version carry no
+// network magic and hold main network chains, and files before the third
+// carry no engine and hold proof of work chains.
+const (
+	bootstrapMagic   = "GBCX"
+	bootstrapVersion = uint32(3)
+	maxBlockSize     = 32 << 20
+	maxEngineSize    = 1 << 20
+)
+
+func (chain *BlockChain) ExportChain(w io.Writer) (int, error) {
+	var hashes [][]byte
+	iter := chain.Iterator()
+	for {
+		block := iter.Next()
+		hashes = append(hashes, block.Hash)
+		if len(block.PreviousHash) == 0 {
+			break
+		}
+	}
+
+	writer := bufio.NewWriter(w)
+	if _, err := writer.WriteString(bootstrapMagic); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(writer, binary.BigEndian, bootstrapVersion); err != nil {
+		return 0, err
+	}
+	if err := binary.Write(writer, binary.BigEndian, chain.params().Magic); err != nil {
+		return 0, err
+	}
+	engine, err := encodeEngine(chain.engine())
+	if err != nil {
+		return 0, err
+	}
+	if err := binary.Write(writer, binary.BigEndian, uint32(len(engine))); err != nil {
+		return 0, err
+	}
+	if _, err := writer.Write(engine); err != nil {
+		return 0, err
+	}
+
+	for i := len(hashes) - 1; i >= 0; i-- {
+		block, err := chain.GetBlock(hashes[i])
+		if err != nil {
+			return 0, err
+		}
+		if block.IsPruned() {
+			return 0, fmt.Errorf("Cannot export block %d: %w", block.Height, ErrPruned)
+		}
+		data := block.Serialize()
+		if err := binary.Write(writer, binary.BigEndian, uint32(len(data))); err != nil {
+			return 0, err
+		}
+		if _, err := writer.Write(data); err != nil {
+			return 0, err
+		}
+	}
+	return len(hashes), writer.Flush()
+}
+
+// readBootstrap checks that a bootstrap file holds a chain of the network
+// params describe, run by engine, and passes every block in it to fn,
+// stopping at the first error. The engine the file records is only compared
+// with engine, never used.
+func readBootstrap(r io.Reader, params *ChainParams, engine ConsensusEngine, fn func(block *Block) error) error {
+	reader := bufio.NewReader(r)
+
+	magic := make([]byte, len(bootstrapMagic))
+	if _, err := io.ReadFull(reader, magic); err != nil || string(magic) != bootstrapMagic {
+		return errors.New("Not a bootstrap file")
+	}
+	var version uint32
+	if err := binary.Read(reader, binary.BigEndian, &version); err != nil {
+		return err
+	}
+	if version < 1 || version > bootstrapVersion {
+		return fmt.Errorf("Unsupported bootstrap file version %d", version)
+	}
+	network := MainParams.Magic
+	if version >= 2 {
+		if err := binary.Read(reader, binary.BigEndian, &network); err != nil {
+			return err
+		}
+	}
+	if network != params.Magic {
+		return fmt.Errorf("The bootstrap file holds a chain of another network than %s", params.Name)
+	}
+	fileEngine, err := encodeEngine(Miner{})
+	if err != nil {
+		return err
+	}
+	if version >= 3 {
+		var size uint32
+		if err := binary.Read(reader, binary.BigEndian, &size); err != nil {
+			return err
+		}
+		if size > maxEngineSize {
+			return fmt.Errorf("Consensus engine record of %d bytes is too large", size)
+		}
+		fileEngine = make([]byte, size)
+		if _, err := io.ReadFull(reader, fileEngine); err != nil {
+			return err
+		}
+	}
+	ownEngine, err := encodeEngine(engine)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(fileEngine, ownEngine) {
+		return errors.New("The bootstrap file holds a chain with another consensus engine or other authorities")
+	}
+
+	for count := 0; ; count++ {
+		var size uint32
+		err := binary.Read(reader, binary.BigEndian, &size)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if size > maxBlockSize {
+			return fmt.Errorf("block %d: record of %d bytes is too large", count, size)
+		}
+		data := make([]byte, size)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return fmt.Errorf("block %d: %v", count, err)
+		}
+		block, err := DeserializeBlock(data)
+		if err != nil {
+			return fmt.Errorf("block %d: %v", count, err)
+		}
+		if err := fn(block); err != nil {
+			return fmt.Errorf("block %d: %w", count, err)
+		}
+	}
+}
+
+// ImportChain replays a bootstrap file of the network params describes into
+// an empty store, validating every block as it would a block received from
+// another node. The genesis block must be the one params describe, and the
+// chain runs the consensus engine of params; a file made with another one
+// is rejected.
+func ImportChain(store ChainStore, params *ChainParams, r io.Reader) (*BlockChain, int, error) {
+	engine, err := params.Engine()
+	if err != nil {
+		return nil, 0, err
+	}
+	var chain *BlockChain
+	count := 0
+	err = readBootstrap(r, params, engine, func(block *Block) error {
+		if chain == nil {
+			rules, err := nextHeaderRules(engine, params, store, nil, time.Now())
+			if err != nil {
+				return err
+			}
+			if err := checkBlockHeader(block, nil, rules, engine); err != nil {
+				return err
+			}
+			if err := checkGenesis(block, params, engine); err != nil {
+				return err
+			}
+			err = store.Update(func(batch Batch) error {
+				return initChain(batch, block, params, engine)
+			})
+			if err != nil {
+				return err
+			}
+			chain = &BlockChain{block.Hash, store, engine, params, nil}
+		} else if err := chain.storeReceivedBlock(block); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	if err == nil && chain == nil {
+		err = errors.New("Bootstrap file contains no blocks")
+	}
+	if err != nil {
+		return nil, count, err
+	}
+	return chain, count, chain.activateBestChain()
+}
+
+// ImportBlocks adds the blocks of a bootstrap file that the chain does not
+// have yet, which may form a competing branch. It returns how many blocks
+// were added.
+func (chain *BlockChain) ImportBlocks(r io.Reader) (int, error) {
+	genesis, err := chain.GetBlockHash(0)
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	first := true
+	err = readBootstrap(r, chain.params(), chain.engine(), func(block *Block) error {
+		if first && !bytes.Equal(block.Hash, genesis) {
+			return errors.New("the file holds a chain with a different genesis block")
+		}
+		first = false
+		if _, err := chain.Database.Get(nodeKey(block.Hash)); err == nil {
+			return nil
+		}
+		if err := chain.storeReceivedBlock(block); err != nil {
+			return err
+		}
+		count++
+		return nil
+	})
+	// The blocks stored before a bad one are still worth connecting.
+	if activateErr := chain.activateBestChain(); err == nil {
+		err = activateErr
+	}
+	return count, err
+}
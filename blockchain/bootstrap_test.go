@@ -0,0 +1,189 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/gustavoddoki/GoBlockchain/wallet"
+)
+
+func testAddress() string {
+	return string(wallet.CreateNewWallet().Address())
+}
+
+// bootstrapFile writes blocks to a bootstrap file of the network params
+// describes.
+func bootstrapFile(t *testing.T, params *ChainParams, blocks ...*Block) *bytes.Buffer {
+	t.Helper()
+	var file bytes.Buffer
+	file.WriteString(bootstrapMagic)
+	binary.Write(&file, binary.BigEndian, bootstrapVersion)
+	binary.Write(&file, binary.BigEndian, params.Magic)
+	engine, err := encodeEngine(Miner{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	binary.Write(&file, binary.BigEndian, uint32(len(engine)))
+	file.Write(engine)
+	for _, block := range blocks {
+		data := block.Serialize()
+		binary.Write(&file, binary.BigEndian, uint32(len(data)))
+		file.Write(data)
+	}
+	return &file
+}
+
+func sealGenesis(t *testing.T, params *ChainParams, coinbase *Transaction) *Block {
+	t.Helper()
+	bits, err := Miner{}.NextBits(NewMemoryStore(), params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	genesis := newBlock([]*Transaction{coinbase}, []byte{}, 0, bits, params.GenesisTime)
+	if err := (Miner{}).Seal(context.Background(), genesis); err != nil {
+		t.Fatal(err)
+	}
+	return genesis
+}
+
+func TestImportChainChecksGenesis(t *testing.T) {
+	params := RegtestParams
+	params.GenesisTime = 1700000000
+	address := testAddress()
+
+	tooLarge := params.genesisCoinbase(address)
+	tooLarge.Outputs[0].Value = params.subsidy(0) + 1
+	tooLarge.SetID()
+	otherMessage := CreateCoinbaseTx(address, "Another genesis", params.subsidy(0))
+
+	tests := []struct {
+		name    string
+		genesis *Block
+		err     error
+	}{
+		{"matches the parameters", sealGenesis(t, &params, params.genesisCoinbase(address)), nil},
+		{"mints too much", sealGenesis(t, &params, tooLarge), ErrCoinbaseTooLarge},
+		{"other message", sealGenesis(t, &params, otherMessage), ErrBadGenesis},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, err := ImportChain(NewMemoryStore(), &params, bootstrapFile(t, &params, test.genesis))
+			if !errors.Is(err, test.err) {
+				t.Errorf("importing got %v, want %v", err, test.err)
+			}
+		})
+	}
+
+	otherTime := params
+	otherTime.GenesisTime++
+	genesis := sealGenesis(t, &otherTime, params.genesisCoinbase(address))
+	_, _, err := ImportChain(NewMemoryStore(), &params, bootstrapFile(t, &params, genesis))
+	if !errors.Is(err, ErrBadGenesis) {
+		t.Errorf("importing a genesis block from another time got %v, want %v", err, ErrBadGenesis)
+	}
+}
+
+// authorityParams are regtest parameters for a proof of authority network
+// signed by signer alone.
+func authorityParams(signer *wallet.Wallet) ChainParams {
+	params := RegtestParams
+	params.AddressVersion = wallet.Version
+	params.Consensus = authorityEngine
+	params.Authorities = []string{string(signer.Address())}
+	return params
+}
+
+func TestExportImportKeepsEngine(t *testing.T) {
+	signer := wallet.CreateNewWallet()
+	authority := AuthorityEngine{[][]byte{wallet.PublicKeyHash(signer.PublicKey)}, []ecdsa.PrivateKey{signer.PrivateKey}}
+	stake := RegtestParams
+	stake.Consensus = stakeEngine
+	tests := []struct {
+		params ChainParams
+		engine ConsensusEngine
+	}{
+		{RegtestParams, Miner{}},
+		{authorityParams(signer), authority},
+		{stake, StakeEngine{[]ecdsa.PrivateKey{signer.PrivateKey}}},
+	}
+
+	for _, test := range tests {
+		engine, params := test.engine, test.params
+		t.Run(fmt.Sprintf("%T", engine), func(t *testing.T) {
+			source := CreateBlockchainWithStore(NewMemoryStore(), testAddress(), engine, &params)
+			if _, ok := engine.(StakeEngine); !ok {
+				block := sealBlock(t, source, mustBlock(t, source, source.LastHash), testAddress())
+				if err := source.AcceptBlock(block); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			var file bytes.Buffer
+			exported, err := source.ExportChain(&file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			chain, imported, err := ImportChain(NewMemoryStore(), &params, &file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if imported != exported || !bytes.Equal(chain.LastHash, source.LastHash) {
+				t.Errorf("imported %d of %d blocks up to %x, want %x", imported, exported, chain.LastHash, source.LastHash)
+			}
+			stored, err := readEngine(chain.Database.Get)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if fmt.Sprintf("%T", stored) != fmt.Sprintf("%T", engine) {
+				t.Errorf("imported chain uses %T", stored)
+			}
+		})
+	}
+}
+
+func TestImportChainRejectsOtherEngine(t *testing.T) {
+	signer := wallet.CreateNewWallet()
+	params := authorityParams(signer)
+	engine := AuthorityEngine{[][]byte{wallet.PublicKeyHash(signer.PublicKey)}, []ecdsa.PrivateKey{signer.PrivateKey}}
+	source := CreateBlockchainWithStore(NewMemoryStore(), testAddress(), engine, &params)
+	var file bytes.Buffer
+	if _, err := source.ExportChain(&file); err != nil {
+		t.Fatal(err)
+	}
+
+	// The file names its own signer as the only authority, which neither a
+	// proof of work network nor one with other authorities may adopt.
+	proofOfWork := params
+	proofOfWork.Consensus, proofOfWork.Authorities = "", nil
+	otherAuthority := authorityParams(wallet.CreateNewWallet())
+	for _, params := range []ChainParams{proofOfWork, otherAuthority} {
+		if _, _, err := ImportChain(NewMemoryStore(), &params, bytes.NewReader(file.Bytes())); err == nil {
+			t.Errorf("importing a chain signed by %s into a network run by %q %v succeeded", signer.Address(), params.Consensus, params.Authorities)
+		}
+	}
+	if _, _, err := ImportChain(NewMemoryStore(), &params, bytes.NewReader(file.Bytes())); err != nil {
+		t.Errorf("importing into the network of the chain: %v", err)
+	}
+}
+
+func TestImportBlocksRejectsOtherEngine(t *testing.T) {
+	params := RegtestParams
+	params.Consensus = stakeEngine
+	staker := wallet.CreateNewWallet()
+	engine := StakeEngine{[]ecdsa.PrivateKey{staker.PrivateKey}}
+	source := CreateBlockchainWithStore(NewMemoryStore(), string(staker.Address()), engine, &params)
+	var file bytes.Buffer
+	if _, err := source.ExportChain(&file); err != nil {
+		t.Fatal(err)
+	}
+
+	chain := &BlockChain{source.LastHash, source.Database, Miner{}, &params, nil}
+	if _, err := chain.ImportBlocks(&file); err == nil {
+		t.Error("importing blocks of a proof of stake chain into a proof of work chain succeeded")
+	}
+}
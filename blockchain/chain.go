@@ -2,139 +2,272 @@ package blockchain
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"encoding/hex"
 	"errors"
 	"fmt"
 	"log"
 	"os"
+	"path/filepath"
 	"runtime"
-
-	"github.com/dgraph-io/badger"
-)
-
-const (
-	dbPath      = "./tmp/blocks"
-	dbFile      = "./tmp/blocks/MANIFEST"
-	genesisData = "First Transaction from Genesis"
+	"time"
 )
 
 type BlockChain struct {
 	LastHash []byte
-	Database *badger.DB
+	Database ChainStore
+	Engine   ConsensusEngine
+	Params   *ChainParams
+	// Clock, if set, replaces time.Now when stamping and checking blocks.
+	Clock func() time.Time
 }
 
 type BlockChainIterator struct {
 	CurrentHash []byte
-	Database    *badger.DB
+	Database    ChainStore
 }
 
-func DBexists() bool {
-	if _, err := os.Stat(dbFile); os.IsNotExist(err) {
+func DBPath(dataDir string) string {
+	return filepath.Join(dataDir, "blocks")
+}
+
+func DBexists(dataDir string) bool {
+	if _, err := os.Stat(filepath.Join(DBPath(dataDir), "MANIFEST")); os.IsNotExist(err) {
 		return false
 	}
 	return true
 }
 
-func (chain *BlockChain) AddBlock(transactions []*Transaction) {
-	var last_hash []byte
-	err := chain.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("lh"))
-		if err != nil {
-			log.Panic(err)
+// addBlock seals a block of transactions on the tip with chain.Engine and
+// connects it. The block is stamped with creationTime, or with the current
+// time, moved past the median time, if creationTime is zero. Sealing is
+// abandoned, and nothing written, once ctx is done; callers cancel it when
+// the tip they are mining on is replaced.
+func (chain *BlockChain) addBlock(ctx context.Context, transactions []*Transaction, creationTime int64) (*Block, error) {
+	last_hash, err := chain.Database.GetTip()
+	if err != nil {
+		return nil, err
+	}
+	last_block, err := chain.Database.GetBlock(last_hash)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := nextHeaderRules(chain.engine(), chain.params(), chain.Database, last_block, chain.now())
+	if err != nil {
+		return nil, err
+	}
+	if creationTime == 0 {
+		creationTime = nextBlockTime(rules)
+	}
+
+	new_block := newBlock(transactions, last_hash, last_block.Height+1, rules.bits, creationTime)
+	if err := chain.engine().Seal(ctx, new_block); err != nil {
+		return nil, err
+	}
+	if err := chain.ValidateBlock(new_block); err != nil {
+		return nil, err
+	}
+	err = chain.Database.Update(func(batch Batch) error {
+		if err := checkTip(batch, last_hash); err != nil {
+			return err
 		}
-		last_hash, err = item.ValueCopy(nil)
-		return err
+		if err := storeBlock(batch, new_block, chain.engine()); err != nil {
+			return err
+		}
+		return connectBlock(batch, new_block)
 	})
 	if err != nil {
-		log.Panic(err)
+		return nil, err
 	}
-	new_block := CreateBlock(transactions, last_hash)
-	err = chain.Database.Update(func(txn *badger.Txn) error {
-		err := txn.Set(new_block.Hash, new_block.Serialize())
-		if err != nil {
-			log.Panic(err)
-		}
-		err = txn.Set([]byte("lh"), new_block.Hash)
-		chain.LastHash = new_block.Hash
+	chain.LastHash = new_block.Hash
+	return new_block, nil
+}
+
+// initChain writes the genesis block of a new chain along with the schema
+// version the store is laid out in, the chain's parameters and its
+// consensus engine.
+func initChain(batch Batch, genesis *Block, params *ChainParams, engine ConsensusEngine) error {
+	err := writeSchemaVersion(batch, CurrentSchemaVersion)
+	if err != nil {
 		return err
-	})
+	}
+	err = batch.Put(paramsKey, params.Serialize())
 	if err != nil {
-		log.Panic(err)
+		return err
 	}
+	err = writeEngine(batch, engine)
+	if err != nil {
+		return err
+	}
+	err = storeBlock(batch, genesis, engine)
+	if err != nil {
+		return err
+	}
+	return connectBlock(batch, genesis)
 }
 
-func CreateBlockchain(address string) *BlockChain {
-	var last_hash []byte
+// checkTip makes sure nothing else extended the chain while a block was
+// being mined or validated on top of expected.
+func checkTip(batch Batch, expected []byte) error {
+	tip, err := batch.GetTip()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(tip, expected) {
+		return fmt.Errorf("chain tip moved from %x to %x", expected, tip)
+	}
+	return nil
+}
 
-	if DBexists() {
+// connectBlock makes a stored block the new tip and updates every index
+// derived from the chain, all in the caller's batch, so a crash leaves
+// either all of it or none of it on disk.
+func connectBlock(batch Batch, block *Block) error {
+	err := batch.SetTip(block.Hash)
+	if err != nil {
+		return err
+	}
+	err = batch.Put(heightKey(block.Height), block.Hash)
+	if err != nil {
+		return err
+	}
+	err = indexTransactions(batch, block)
+	if err != nil {
+		return err
+	}
+	err = applyBlock(batch, block)
+	if err != nil {
+		return err
+	}
+	err = setStatus(batch, block.Hash, StatusValid)
+	if err != nil {
+		return err
+	}
+	return pruneBehind(batch, block)
+}
+
+// applyBlock updates the state derived from the outputs a block spends and
+// creates: the address index and the UTXO set.
+func applyBlock(batch Batch, block *Block) error {
+	err := indexAddresses(batch, block)
+	if err != nil {
+		return err
+	}
+	err = UTXOSet{}.Update(batch, block)
+	if err != nil {
+		return err
+	}
+	return batch.Put(utxoTipKey, block.Hash)
+}
+
+// revertBlock undoes applyBlock for a block leaving the main chain.
+func revertBlock(batch Batch, block *Block) error {
+	err := deindexAddresses(batch, block)
+	if err != nil {
+		return err
+	}
+	err = UTXOSet{}.Revert(batch, block)
+	if err != nil {
+		return err
+	}
+	return batch.Put(utxoTipKey, block.PreviousHash)
+}
+
+func CreateBlockchain(address string, dataDir string, engine ConsensusEngine, params *ChainParams) *BlockChain {
+	if DBexists(dataDir) {
 		fmt.Println("Blockchain already exists.")
 		runtime.Goexit()
 	}
 
-	opts := badger.DefaultOptions("")
-	opts.Dir = dbPath
-	opts.ValueDir = dbPath
-
-	db, err := badger.Open(opts)
+	store, err := OpenBadgerStore(DBPath(dataDir))
 	if err != nil {
 		log.Panic(err)
 	}
 
-	err = db.Update(func(txn *badger.Txn) error {
-		cbtx := CreateCoinbaseTx(address, genesisData)
-		genesis := CreateGenesisBlock(cbtx)
-		fmt.Println("Genesis Block created")
-
-		err = txn.Set(genesis.Hash, genesis.Serialize())
-		if err != nil {
-			log.Panic(err)
-		}
-		err = txn.Set([]byte("lh"), genesis.Hash)
+	return CreateBlockchainWithStore(store, address, engine, params)
+}
 
-		last_hash = genesis.Hash
+// CreateBlockchainWithStore seals a genesis block paying address with
+// engine, which must be the engine params describe, and writes it to an
+// empty store. The chain keeps using the engine for every block, and every
+// block, the genesis block included, may mint coins according to the reward
+// schedule of params.
+func CreateBlockchainWithStore(store ChainStore, address string, engine ConsensusEngine, params *ChainParams) *BlockChain {
+	if err := params.Validate(); err != nil {
+		log.Panic(err)
+	}
+	if err := params.checkEngine(engine); err != nil {
+		log.Panic(err)
+	}
+	bits, err := engine.NextBits(store, params, nil)
+	if err != nil {
+		log.Panic(err)
+	}
+	creationTime := params.GenesisTime
+	if creationTime == 0 {
+		creationTime = time.Now().Unix()
+	}
+	genesis := newBlock([]*Transaction{params.genesisCoinbase(address)}, []byte{}, 0, bits, creationTime)
+	err = engine.Seal(context.Background(), genesis)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Println("Genesis Block created")
 
-		return err
+	err = store.Update(func(batch Batch) error {
+		return initChain(batch, genesis, params, engine)
 	})
 
 	if err != nil {
 		log.Panic(err)
 	}
 
-	blockchain := BlockChain{last_hash, db}
+	blockchain := BlockChain{genesis.Hash, store, engine, params, nil}
 	return &blockchain
 }
 
-func ContinueBlockChain(address string) *BlockChain {
+func ContinueBlockChain(address string, dataDir string) *BlockChain {
 
-	if !DBexists() {
+	if !DBexists(dataDir) {
 		fmt.Println("Blockchain does not exist.")
 		runtime.Goexit()
 	}
 
-	var last_hash []byte
+	store, err := OpenBadgerStore(DBPath(dataDir))
+	if err != nil {
+		log.Panic(err)
+	}
 
-	opts := badger.DefaultOptions("")
-	opts.Dir = dbPath
-	opts.ValueDir = dbPath
+	return LoadBlockChain(store)
+}
 
-	db, err := badger.Open(opts)
+// LoadBlockChain resumes a chain previously written to store, upgrading it
+// to the current schema and repairing indexes that were left behind the tip.
+func LoadBlockChain(store ChainStore) *BlockChain {
+	report, err := Migrate(store, false)
 	if err != nil {
 		log.Panic(err)
 	}
+	for _, line := range report {
+		fmt.Println("Migrated", line)
+	}
 
-	err = db.Update(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte("lh"))
-		if err != nil {
-			log.Panic(err)
-		}
-		last_hash, err = item.ValueCopy(nil)
-		return err
-	})
+	last_hash, err := store.GetTip()
 	if err != nil {
-		log.Panic(nil)
+		log.Panic(err)
 	}
-	chain := BlockChain{last_hash, db}
+	engine, err := readEngine(store.Get)
+	if err != nil {
+		log.Panic(err)
+	}
+	params, err := readParams(store.Get)
+	if err != nil {
+		log.Panic(err)
+	}
+	chain := BlockChain{last_hash, store, engine, params, nil}
+	chain.recover()
 	return &chain
 }
 
@@ -143,108 +276,86 @@ func (chain *BlockChain) Iterator() *BlockChainIterator {
 	return iter
 }
 
-func (iter *BlockChainIterator) Next() *Block {
-	var block *Block
-	err := iter.Database.View(func(txn *badger.Txn) error {
-		item, err := txn.Get(iter.CurrentHash)
-		if err != nil {
-			log.Panic(err)
-		}
-		encoded_block, err := item.ValueCopy(nil)
-		block = Deserialize(encoded_block)
-		return err
-	})
-	if err != nil {
-		log.Panic(err)
+func heightKey(height int) []byte {
+	return append(append([]byte{}, heightPrefix...), ConvertIntToHex(int64(height))...)
+}
+
+func (chain *BlockChain) GetBlock(hash []byte) (*Block, error) {
+	block, err := chain.Database.GetBlock(hash)
+	if errors.Is(err, ErrNotFound) {
+		return nil, errors.New("Block does not exist")
 	}
-	iter.CurrentHash = block.PreviousHash
-	return block
+	return block, err
 }
 
-func (chain *BlockChain) FindUnspentTransactions(pubKeyHash []byte) []Transaction {
-	var unspent_txs []Transaction
-	spent_txs0 := make(map[string][]int)
-	iter := chain.Iterator()
+func (chain *BlockChain) GetBlockHash(height int) ([]byte, error) {
+	hash, err := chain.Database.Get(heightKey(height))
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("No block at height %d", height)
+	}
+	return hash, err
+}
 
-	for {
-		block := iter.Next()
-		for _, tx := range block.Transactions {
-			txID := hex.EncodeToString(tx.ID)
-
-		Outputs:
-			for out_id, out := range tx.Outputs {
-				if spent_txs0[txID] != nil {
-					for _, spent_out := range spent_txs0[txID] {
-						if spent_out == out_id {
-							continue Outputs
-						}
-					}
-				}
-				if out.IsLockedWithKey(pubKeyHash) {
-					unspent_txs = append(unspent_txs, *tx)
-				}
-			}
-			if !tx.FlagCoinbaseTx() {
-				for _, in := range tx.Inputs {
-					if in.UsesKey(pubKeyHash) {
-						inTxID := hex.EncodeToString(in.ID)
-						spent_txs0[inTxID] = append(spent_txs0[inTxID], in.Out)
-					}
-				}
-			}
-		}
-		if len(block.PreviousHash) == 0 {
-			break
-		}
+func (chain *BlockChain) GetBlockByHeight(height int) (*Block, error) {
+	hash, err := chain.GetBlockHash(height)
+	if err != nil {
+		return nil, err
 	}
-	return unspent_txs
+	return chain.GetBlock(hash)
 }
 
-func (chain *BlockChain) FindUXT0(pubKeyHash []byte) []TxOutput {
-	var UTX0s []TxOutput
-	unspent_txs := chain.FindUnspentTransactions(pubKeyHash)
+func (chain *BlockChain) GetBestHeight() int {
+	block, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		log.Panic(err)
+	}
+	return block.Height
+}
 
-	for _, tx := range unspent_txs {
-		for _, out := range tx.Outputs {
-			if out.IsLockedWithKey(pubKeyHash) {
-				UTX0s = append(UTX0s, out)
-			}
-		}
+func (iter *BlockChainIterator) Next() *Block {
+	block, err := iter.Database.GetBlock(iter.CurrentHash)
+	if err != nil {
+		log.Panic(err)
 	}
-	return UTX0s
+	iter.CurrentHash = block.PreviousHash
+	return block
 }
 
-func (chain *BlockChain) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
-	unspent_outs := make(map[string][]int)
-	unspent_txs := chain.FindUnspentTransactions(pubKeyHash)
-	accumulated := 0
-
-Work:
-	for _, tx := range unspent_txs {
-		txID := hex.EncodeToString(tx.ID)
-		for out_id, out := range tx.Outputs {
-			if out.IsLockedWithKey(pubKeyHash) && accumulated < amount {
-				accumulated += out.Value
-				unspent_outs[txID] = append(unspent_outs[txID], out_id)
-			}
+func (blockchain *BlockChain) FindTransaction(ID []byte) (Transaction, error) {
+	tx, _, err := blockchain.FindTransactionBlock(ID)
+	return tx, err
+}
 
-			if accumulated >= amount {
-				break Work
-			}
+func (blockchain *BlockChain) FindTransactionBlock(ID []byte) (Transaction, *Block, error) {
+	loc, indexed, err := blockchain.lookupTxIndex(ID)
+	if err != nil {
+		return Transaction{}, nil, err
+	}
+	if indexed {
+		if loc == nil {
+			return Transaction{}, nil, errors.New("Transaction does not exist")
+		}
+		block, err := blockchain.GetBlock(loc.BlockHash)
+		if err != nil {
+			return Transaction{}, nil, err
 		}
+		if block.IsPruned() {
+			return Transaction{}, nil, fmt.Errorf("Transaction %x is in block %d: %w", ID, block.Height, ErrPruned)
+		}
+		return *block.Transactions[loc.Position], block, nil
 	}
-	return accumulated, unspent_outs
-}
 
-func (blockchain *BlockChain) FindTransaction(ID []byte) (Transaction, error) {
 	iter := blockchain.Iterator()
 
 	for {
 		block := iter.Next()
+		if block.IsPruned() {
+			return Transaction{}, nil, fmt.Errorf("Transaction not found in the blocks that were not pruned: %w", ErrPruned)
+		}
 
 		for _, tx := range block.Transactions {
 			if bytes.Equal(tx.ID, ID) {
-				return *tx, nil
+				return *tx, block, nil
 			}
 		}
 
@@ -253,14 +364,36 @@ func (blockchain *BlockChain) FindTransaction(ID []byte) (Transaction, error) {
 		}
 	}
 
-	return Transaction{}, errors.New("Transaction does not exist")
+	return Transaction{}, nil, errors.New("Transaction does not exist")
+}
+
+// outputsBefore looks up outputs created by main chain blocks below height,
+// whether or not they have been spent since.
+func (blockchain *BlockChain) outputsBefore(height int) outputLookup {
+	return func(ID []byte, out int) (outputEntry, error) {
+		tx, block, err := blockchain.FindTransactionBlock(ID)
+		if err != nil {
+			return outputEntry{}, err
+		}
+		if block.Height >= height {
+			return outputEntry{}, fmt.Errorf("Transaction %x is in block %d, not below %d: %w", ID, block.Height, height, ErrNotFound)
+		}
+		if out < 0 || out >= len(tx.Outputs) {
+			return outputEntry{}, fmt.Errorf("Transaction %x has no output %d", ID, out)
+		}
+		entry := outputEntry{tx.Outputs[out], -1}
+		if tx.FlagCoinbaseTx() {
+			entry.CoinbaseHeight = block.Height
+		}
+		return entry, nil
+	}
 }
 
 func (blockchain *BlockChain) SignTransaction(transaction *Transaction, privKey ecdsa.PrivateKey) {
 	prevTXs := make(map[string]Transaction)
 
 	for _, in := range transaction.Inputs {
-		prevTX, err := blockchain.FindTransaction(in.ID)
+		prevTX, err := UTXOSet{blockchain}.UnspentTransaction(in.ID)
 		if err != nil {
 			log.Panic(err)
 		}
@@ -274,7 +407,7 @@ func (blockchain *BlockChain) VerifyTransaction(transaction *Transaction) bool {
 	prevTXs := make(map[string]Transaction)
 
 	for _, in := range transaction.Inputs {
-		prevTX, err := blockchain.FindTransaction(in.ID)
+		prevTX, err := UTXOSet{blockchain}.UnspentTransaction(in.ID)
 		if err != nil {
 			log.Panic(err)
 		}
@@ -0,0 +1,87 @@
+package blockchain
+
+import (
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/gustavoddoki/GoBlockchain/wallet"
+)
+
+// newTestChain creates a chain in memory whose genesis block pays the
+// returned wallet.
+func newTestChain(t *testing.T, params *ChainParams) (*BlockChain, *wallet.Wallet) {
+	t.Helper()
+	owner := wallet.CreateNewWallet()
+	chain := CreateBlockchainWithStore(NewMemoryStore(), string(owner.Address()), Miner{}, params)
+	return chain, owner
+}
+
+// sealBlock seals a block on parent with a coinbase paying the subsidy to
+// to, followed by txs. The block is neither validated nor stored.
+func sealBlock(t *testing.T, chain *BlockChain, parent *Block, to string, txs ...*Transaction) *Block {
+	t.Helper()
+	return sealBlockAt(t, chain, parent, 0, to, txs...)
+}
+
+// sealBlockAt is sealBlock for a block stamped with creationTime, or the
+// time a block mined now would get if it is zero.
+func sealBlockAt(t *testing.T, chain *BlockChain, parent *Block, creationTime int64, to string, txs ...*Transaction) *Block {
+	t.Helper()
+	coinbase := CreateCoinbaseTx(to, "", chain.params().subsidy(parent.Height+1))
+	return sealTransactions(t, chain, parent, creationTime, append([]*Transaction{coinbase}, txs...)...)
+}
+
+// sealTransactions seals a block of exactly txs on parent, stamped with
+// creationTime or, if it is zero, the time a block mined now would get.
+func sealTransactions(t *testing.T, chain *BlockChain, parent *Block, creationTime int64, txs ...*Transaction) *Block {
+	t.Helper()
+	rules, err := nextHeaderRules(chain.engine(), chain.params(), chain.Database, parent, chain.now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if creationTime == 0 {
+		creationTime = nextBlockTime(rules)
+	}
+	block := newBlock(txs, parent.Hash, parent.Height+1, rules.bits, creationTime)
+	if err := chain.engine().Seal(context.Background(), block); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+// sealHeader seals a block on parent with a coinbase paying the subsidy,
+// carrying whatever version, target and creation time it is given.
+func sealHeader(t *testing.T, chain *BlockChain, parent *Block, version int, bits uint32, creationTime int64) *Block {
+	t.Helper()
+	coinbase := CreateCoinbaseTx(testAddress(), "", chain.params().subsidy(parent.Height+1))
+	block := newBlock([]*Transaction{coinbase}, parent.Hash, parent.Height+1, bits, creationTime)
+	block.Version = version
+	if err := chain.engine().Seal(context.Background(), block); err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
+
+// spend pays the whole of output out of prev to to, signed by signer.
+func spend(prev *Transaction, out int, owner *wallet.Wallet, signer *wallet.Wallet, to string) *Transaction {
+	return spendTo(prev, out, owner, signer, *NewTXOutput(prev.Outputs[out].Value, to))
+}
+
+// spendTo spends output out of prev, owned by owner and signed by signer,
+// on outputs.
+func spendTo(prev *Transaction, out int, owner *wallet.Wallet, signer *wallet.Wallet, outputs ...TxOutput) *Transaction {
+	tx := Transaction{nil, []TxInput{{prev.ID, out, nil, owner.PublicKey}}, outputs}
+	tx.Sign(signer.PrivateKey, map[string]Transaction{hex.EncodeToString(prev.ID): *prev})
+	tx.SetID()
+	return &tx
+}
+
+func mustBlock(t *testing.T, chain *BlockChain, hash []byte) *Block {
+	t.Helper()
+	block, err := chain.GetBlock(hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return block
+}
@@ -0,0 +1,98 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+func validateCheckpoint(height int, hash string) error {
+	if height < 0 {
+		return fmt.Errorf("Checkpoint height must not be negative, got %d", height)
+	}
+	decoded, err := hex.DecodeString(hash)
+	if err != nil || len(decoded) != 32 {
+		return fmt.Errorf("Checkpoint at height %d is not a block hash: %q", height, hash)
+	}
+	return nil
+}
+
+// checkpoint returns the hash the block at height must have, or nil if
+// there is no checkpoint at that height.
+func (params *ChainParams) checkpoint(height int) []byte {
+	hash, ok := params.Checkpoints[height]
+	if !ok {
+		return nil
+	}
+	decoded, err := hex.DecodeString(hash)
+	if err != nil {
+		return nil
+	}
+	return decoded
+}
+
+// lastCheckpoint returns the height of the highest checkpoint, or -1 if
+// there are none.
+func (params *ChainParams) lastCheckpoint() int {
+	last := -1
+	for height := range params.Checkpoints {
+		if height > last {
+			last = height
+		}
+	}
+	return last
+}
+
+func checkCheckpoint(block *Block, checkpoint []byte) error {
+	if checkpoint != nil && !bytes.Equal(block.Hash, checkpoint) {
+		return ruleError(ErrCheckpoint, "block %x at height %d does not match the checkpoint %x", block.Hash, block.Height, checkpoint)
+	}
+	return nil
+}
+
+// checkCheckpointFork rejects a block that would fork a main chain whose tip
+// is at tipHeight below a checkpoint the main chain has already passed.
+// However much work such a branch has, it cannot become the main chain.
+func (params *ChainParams) checkCheckpointFork(block *Block, tipHeight int) error {
+	last := params.lastCheckpoint()
+	if block.Height <= last && tipHeight >= last {
+		return ruleError(ErrCheckpoint, "block %x forks the chain at height %d, below the checkpoint at height %d",
+			block.Hash, block.Height, last)
+	}
+	return nil
+}
+
+// checkpointedBlocks returns the hashes of the blocks from the last
+// checkpoint back to height, which the checkpoint vouches for. It returns
+// none until the checkpointed block itself has been stored, since until
+// then nothing shows which blocks it builds on.
+func (params *ChainParams) checkpointedBlocks(get func(key []byte) ([]byte, error), height int) (map[string]bool, error) {
+	last := params.lastCheckpoint()
+	if height > last {
+		return nil, nil
+	}
+	hash := params.checkpoint(last)
+	if _, err := get(nodeKey(hash)); errors.Is(err, ErrNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	trusted := make(map[string]bool)
+	for {
+		block, err := readBlock(get, hash)
+		if err != nil {
+			return nil, err
+		}
+		if block.Height < height {
+			break
+		}
+		trusted[string(block.Hash)] = true
+		if len(block.PreviousHash) == 0 {
+			break
+		}
+		hash = block.PreviousHash
+	}
+	return trusted, nil
+}
@@ -0,0 +1,51 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/gustavoddoki/GoBlockchain/wallet"
+)
+
+func TestCheckpointVouchesOnlyForItsAncestors(t *testing.T) {
+	params := RegtestParams
+	source, owner := newTestChain(t, &params)
+	genesis := mustBlock(t, source, source.LastHash)
+
+	// Block 1 spends the genesis coinbase with a signature by the wrong key.
+	forged := spend(genesis.Transactions[0], 0, owner, wallet.CreateNewWallet(), testAddress())
+	block1 := sealBlock(t, source, genesis, testAddress(), forged)
+	if err := source.storeReceivedBlock(block1); err != nil {
+		t.Fatal(err)
+	}
+	block2 := sealBlock(t, source, block1, testAddress())
+	if err := source.storeReceivedBlock(block2); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name        string
+		checkpoints map[int]string
+		err         error
+	}{
+		{"no checkpoint", nil, ErrBadSignature},
+		{"checkpoint not in the file", map[int]string{5: hex.EncodeToString(make([]byte, 32))}, ErrBadSignature},
+		{"checkpoint on the block above", map[int]string{2: hex.EncodeToString(block2.Hash)}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			params := params
+			params.Checkpoints = test.checkpoints
+			file := bootstrapFile(t, &params, genesis, block1, block2)
+			chain, _, err := ImportChain(NewMemoryStore(), &params, file)
+			if !errors.Is(err, test.err) {
+				t.Fatalf("importing got %v, want %v", err, test.err)
+			}
+			if err == nil && !bytes.Equal(chain.LastHash, block2.Hash) {
+				t.Errorf("tip is %x, want %x", chain.LastHash, block2.Hash)
+			}
+		})
+	}
+}
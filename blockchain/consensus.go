@@ -0,0 +1,202 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+
+	"github.com/gustavoddoki/GoBlockchain/wallet"
+)
+
+// ConsensusEngine decides who may seal blocks and how much each block counts
+// when choosing between branches. Miner is the proof of work engine,
+// AuthorityEngine lets a fixed set of signers take turns and StakeEngine
+// lets holders of coins seal blocks in proportion to their stake.
+type ConsensusEngine interface {
+	// NextBits returns the difficulty bits required of the block after
+	// parent, or of a genesis block when parent is nil, on a network
+	// launched with params.
+	NextBits(store ChainStore, params *ChainParams, parent *Block) (uint32, error)
+	// Seal sets the hash of a block whose other header fields are final,
+	// along with whatever the engine proves the block with. It gives up with
+	// the context's error once ctx is done.
+	Seal(ctx context.Context, block *Block) error
+	// VerifyHeader checks the proof a block was sealed with. The hash is
+	// already known to match the header.
+	VerifyHeader(block *Block) error
+	// Weight is what the block adds to its branch. The valid branch with
+	// the most weight is the main chain.
+	Weight(block *Block) *big.Int
+}
+
+// Names the engines are recorded under in the store.
+const (
+	proofOfWorkEngine = "pow"
+	authorityEngine   = "poa"
+	stakeEngine       = "pos"
+)
+
+// engineConfig is the part of an engine that every node following the chain
+// must agree on. Settings that only affect sealing, like mining threads or
+// signing keys, are left to each node.
+type engineConfig struct {
+	Name        string
+	Authorities [][]byte
+}
+
+func writeEngine(batch Batch, engine ConsensusEngine) error {
+	value, err := encodeEngine(engine)
+	if err != nil {
+		return err
+	}
+	return batch.Put(consensusKey, value)
+}
+
+// readEngine returns the engine the chain was created with. Chains created
+// before engines were recorded use proof of work.
+func readEngine(get func(key []byte) ([]byte, error)) (ConsensusEngine, error) {
+	value, err := get(consensusKey)
+	if errors.Is(err, ErrNotFound) {
+		return Miner{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return decodeEngine(value)
+}
+
+func encodeEngine(engine ConsensusEngine) ([]byte, error) {
+	var config engineConfig
+	switch engine := engine.(type) {
+	case Miner:
+		config = engineConfig{proofOfWorkEngine, nil}
+	case AuthorityEngine:
+		config = engineConfig{authorityEngine, engine.Authorities}
+	case StakeEngine:
+		config = engineConfig{stakeEngine, nil}
+	default:
+		return nil, fmt.Errorf("Unknown consensus engine %T", engine)
+	}
+
+	var buffer bytes.Buffer
+	if err := gob.NewEncoder(&buffer).Encode(config); err != nil {
+		log.Panic(err)
+	}
+	return buffer.Bytes(), nil
+}
+
+func decodeEngine(value []byte) (ConsensusEngine, error) {
+	var config engineConfig
+	if err := gob.NewDecoder(bytes.NewReader(value)).Decode(&config); err != nil {
+		return nil, err
+	}
+	switch config.Name {
+	case proofOfWorkEngine:
+		return Miner{}, nil
+	case authorityEngine:
+		return AuthorityEngine{config.Authorities, nil}, nil
+	case stakeEngine:
+		return StakeEngine{nil}, nil
+	}
+	return nil, fmt.Errorf("Unknown consensus engine %q", config.Name)
+}
+
+// Engine returns the consensus engine of the network params describe,
+// without any keys to seal blocks with.
+func (params *ChainParams) Engine() (ConsensusEngine, error) {
+	switch params.Consensus {
+	case "", proofOfWorkEngine:
+		return Miner{}, nil
+	case authorityEngine:
+		var signers [][]byte
+		for _, authority := range params.Authorities {
+			pubKeyHash := wallet.Base58Decode([]byte(authority))
+			signers = append(signers, pubKeyHash[1:len(pubKeyHash)-4])
+		}
+		return AuthorityEngine{signers, nil}, nil
+	case stakeEngine:
+		return StakeEngine{nil}, nil
+	}
+	return nil, fmt.Errorf("Unknown consensus %q, expected pow, poa or pos", params.Consensus)
+}
+
+// checkEngine checks that engine is the one params describe, whatever keys
+// it seals with.
+func (params *ChainParams) checkEngine(engine ConsensusEngine) error {
+	expected, err := params.Engine()
+	if err != nil {
+		return err
+	}
+	want, err := encodeEngine(expected)
+	if err != nil {
+		return err
+	}
+	got, err := encodeEngine(engine)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(got, want) {
+		return fmt.Errorf("The %s network does not run %T with these authorities", params.Name, engine)
+	}
+	return nil
+}
+
+// engine returns the chain's consensus engine, which defaults to proof of
+// work.
+func (chain *BlockChain) engine() ConsensusEngine {
+	if chain.Engine != nil {
+		return chain.Engine
+	}
+	return Miner{}
+}
+
+func publicKeyBytes(key ecdsa.PrivateKey) []byte {
+	pubKey := make([]byte, 64)
+	key.PublicKey.X.FillBytes(pubKey[:32])
+	key.PublicKey.Y.FillBytes(pubKey[32:])
+	return pubKey
+}
+
+// signBlock seals a block with a signature over its hash, for engines that
+// do not need a proof of work. The nonce stays zero.
+func signBlock(block *Block, key ecdsa.PrivateKey) error {
+	block.Nonce = 0
+	hash := sha256.Sum256(CreateProofOfWork(block).ProcessData(block.Nonce))
+	r, s, err := ecdsa.Sign(rand.Reader, &key, hash[:])
+	if err != nil {
+		return err
+	}
+	signature := make([]byte, 64)
+	r.FillBytes(signature[:32])
+	s.FillBytes(signature[32:])
+
+	block.Hash = hash[:]
+	block.Signature = signature
+	block.PubKey = publicKeyBytes(key)
+	return nil
+}
+
+// verifyBlockSignature checks that the block hash was signed with the key
+// in block.PubKey. Who that key belongs to is up to the engine.
+func verifyBlockSignature(block *Block) error {
+	if len(block.PubKey) != 64 || len(block.Signature) != 64 {
+		return ruleError(ErrBadSeal, "block %x is not signed", block.Hash)
+	}
+	x := new(big.Int).SetBytes(block.PubKey[:32])
+	y := new(big.Int).SetBytes(block.PubKey[32:])
+	r := new(big.Int).SetBytes(block.Signature[:32])
+	s := new(big.Int).SetBytes(block.Signature[32:])
+	pubKey := ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y}
+	if !ecdsa.Verify(&pubKey, block.Hash, r, s) {
+		return ruleError(ErrBadSeal, "block %x has an invalid signature", block.Hash)
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package blockchain
+
+import (
+	"fmt"
+	"math/big"
+)
+
+const (
+	// legacyDifficulty is the number of leading zero bits required of blocks
+	// mined before the target was stored in the block header.
+	legacyDifficulty = 12
+	// retargetInterval is the number of blocks between difficulty changes.
+	retargetInterval = 10
+	// maxRetargetFactor bounds how much the target may move in one retarget.
+	maxRetargetFactor = 4
+)
+
+// legacyPowLimit is the target of blocks mined before the target was stored
+// in the block header.
+var legacyPowLimit = new(big.Int).Lsh(big.NewInt(1), 256-legacyDifficulty)
+
+// CompactToBig expands the compact "bits" form of a target: the top byte is
+// the length of the target in bytes and the lower three are its most
+// significant bytes.
+func CompactToBig(bits uint32) *big.Int {
+	mantissa := int64(bits & 0x007fffff)
+	exponent := uint(bits >> 24)
+
+	target := big.NewInt(mantissa)
+	if exponent <= 3 {
+		return target.Rsh(target, 8*(3-exponent))
+	}
+	return target.Lsh(target, 8*(exponent-3))
+}
+
+// BigToCompact is the inverse of CompactToBig. Precision beyond the three
+// most significant bytes is lost.
+func BigToCompact(target *big.Int) uint32 {
+	exponent := uint((target.BitLen() + 7) / 8)
+
+	var mantissa uint32
+	if exponent <= 3 {
+		mantissa = uint32(target.Uint64() << (8 * (3 - exponent)))
+	} else {
+		mantissa = uint32(new(big.Int).Rsh(target, 8*(exponent-3)).Uint64())
+	}
+
+	// The top mantissa bit is a sign bit, so move a byte into the exponent
+	// rather than set it.
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+	return uint32(exponent)<<24 | mantissa
+}
+
+// Target returns the proof of work target the block was mined against.
+func (block *Block) Target() *big.Int {
+	if block.Bits == 0 {
+		return new(big.Int).Set(legacyPowLimit)
+	}
+	return CompactToBig(block.Bits)
+}
+
+// Work returns the expected number of hashes it took to mine the block,
+// 2^256 / (target + 1).
+func (block *Block) Work() *big.Int {
+	denominator := new(big.Int).Add(block.Target(), big.NewInt(1))
+	return new(big.Int).Div(new(big.Int).Lsh(big.NewInt(1), 256), denominator)
+}
+
+// nextBits returns the proof of work target required of the block after
+// parent.
+func nextBits(store ChainStore, params *ChainParams, parent *Block) (uint32, error) {
+	return retarget(store, params, parent, params.powLimit())
+}
+
+// retarget returns the target required of the block after parent. It stays
+// the same within a retarget interval and at the start of each new interval
+// is scaled by how long the previous interval actually took compared to the
+// network's target block time, never getting easier than limit. Networks
+// that do not retarget stay at limit.
+func retarget(store ChainStore, params *ChainParams, parent *Block, limit *big.Int) (uint32, error) {
+	if parent == nil || params.NoRetarget {
+		return BigToCompact(limit), nil
+	}
+	height := parent.Height + 1
+	if height%retargetInterval != 0 {
+		return BigToCompact(parent.Target()), nil
+	}
+
+	first := parent
+	for i := 0; i < retargetInterval-1; i++ {
+		block, err := store.GetBlock(first.PreviousHash)
+		if err != nil {
+			return 0, fmt.Errorf("looking up block %d for retarget: %w", first.Height-1, err)
+		}
+		first = block
+	}
+
+	expected := int64(retargetInterval-1) * params.TargetBlockTime
+	elapsed := parent.CreationTime - first.CreationTime
+	if elapsed < expected/maxRetargetFactor {
+		elapsed = expected / maxRetargetFactor
+	}
+	if elapsed > expected*maxRetargetFactor {
+		elapsed = expected * maxRetargetFactor
+	}
+
+	target := parent.Target()
+	target.Mul(target, big.NewInt(elapsed))
+	target.Div(target, big.NewInt(expected))
+	if target.Cmp(limit) > 0 {
+		target.Set(limit)
+	}
+	return BigToCompact(target), nil
+}
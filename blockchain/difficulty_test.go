@@ -0,0 +1,110 @@
+package blockchain
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+)
+
+// storeInterval stores a retarget interval of blocks mined spacing seconds
+// apart at target and returns the last of them.
+func storeInterval(t *testing.T, store ChainStore, target *big.Int, spacing int64) *Block {
+	t.Helper()
+	var parent *Block
+	err := store.Update(func(batch Batch) error {
+		for height := 0; height < retargetInterval; height++ {
+			block := &Block{Height: height, Bits: BigToCompact(target), CreationTime: int64(height) * spacing}
+			block.Hash = []byte{byte(height)}
+			if parent != nil {
+				block.PreviousHash = parent.Hash
+			}
+			if err := batch.PutBlock(block); err != nil {
+				return err
+			}
+			parent = block
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parent
+}
+
+func TestRetarget(t *testing.T) {
+	params := MainParams
+	limit := params.powLimit()
+	target := new(big.Int).Rsh(limit, 4)
+	scaled := func(numerator, denominator int64) *big.Int {
+		scaled := new(big.Int).Mul(target, big.NewInt(numerator))
+		return scaled.Div(scaled, big.NewInt(denominator))
+	}
+	tests := []struct {
+		name    string
+		target  *big.Int
+		spacing int64
+		want    *big.Int
+	}{
+		{"on time", target, params.TargetBlockTime, target},
+		{"twice as fast", target, params.TargetBlockTime / 2, scaled(1, 2)},
+		{"twice as slow", target, params.TargetBlockTime * 2, scaled(2, 1)},
+		{"clamped faster", target, 1, scaled(1, maxRetargetFactor)},
+		{"clamped slower", target, params.TargetBlockTime * 100, scaled(maxRetargetFactor, 1)},
+		{"limit", new(big.Int).Rsh(limit, 1), params.TargetBlockTime * 4, limit},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			store := NewMemoryStore()
+			parent := storeInterval(t, store, test.target, test.spacing)
+			bits, err := nextBits(store, &params, parent)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if want := BigToCompact(test.want); bits != want {
+				t.Errorf("next bits %08x, want %08x", bits, want)
+			}
+		})
+	}
+}
+
+func TestRetargetOnlyAtIntervals(t *testing.T) {
+	params := MainParams
+	target := new(big.Int).Rsh(params.powLimit(), 4)
+	store := NewMemoryStore()
+	parent := storeInterval(t, store, target, 1)
+
+	// Inside an interval the target stays that of the parent.
+	inside, err := store.GetBlock([]byte{retargetInterval / 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bits, err := nextBits(store, &params, inside); err != nil || bits != inside.Bits {
+		t.Errorf("next bits inside an interval %08x, %v, want %08x", bits, err, inside.Bits)
+	}
+
+	// Networks that do not retarget stay at their limit.
+	params.NoRetarget = true
+	if bits, err := nextBits(store, &params, parent); err != nil || bits != BigToCompact(params.powLimit()) {
+		t.Errorf("next bits without retargeting %08x, %v, want %08x", bits, err, BigToCompact(params.powLimit()))
+	}
+}
+
+func TestLegacyTargetFollowsHeight(t *testing.T) {
+	chain := LoadBlockChain(loadFixture(t, "schema0-three.txt"))
+	tip := mustBlock(t, chain, chain.LastHash)
+	rules, err := nextHeaderRules(chain.engine(), chain.params(), chain.Database, tip, chain.now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Above the legacy height a block must carry the target, even one that
+	// is mined at the legacy difficulty anyway.
+	block := sealHeader(t, chain, tip, currentBlockVersion, 0, rules.now.Unix())
+	if err := chain.ValidateBlock(block); !errors.Is(err, ErrBadTarget) {
+		t.Errorf("block without a target got %v, want %v", err, ErrBadTarget)
+	}
+	block = sealHeader(t, chain, tip, currentBlockVersion, rules.bits, rules.now.Unix())
+	if err := chain.ValidateBlock(block); err != nil {
+		t.Errorf("block with the target: %v", err)
+	}
+}
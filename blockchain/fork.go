@@ -0,0 +1,418 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"sort"
+)
+
+type BlockStatus int
+
+const (
+	// StatusHeaderValid blocks passed the header checks, but their
+	// transactions are only checked once their branch becomes the main chain.
+	StatusHeaderValid BlockStatus = iota
+	// StatusValid blocks have been connected to the main chain.
+	StatusValid
+	// StatusInvalid blocks broke a rule, or build on a block that did.
+	StatusInvalid
+)
+
+// blockNode is what the store knows about a block besides its contents.
+type blockNode struct {
+	// Work is the total work of the chain ending at the block.
+	Work   []byte
+	Status BlockStatus
+}
+
+func (node blockNode) Serialize() []byte {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	err := encoder.Encode(node)
+	if err != nil {
+		log.Panic(err)
+	}
+	return buffer.Bytes()
+}
+
+func nodeKey(hash []byte) []byte {
+	return append(append([]byte{}, nodePrefix...), hash...)
+}
+
+func chainTipKey(hash []byte) []byte {
+	return append(append([]byte{}, chainTipPrefix...), hash...)
+}
+
+func readNode(get func(key []byte) ([]byte, error), hash []byte) (blockNode, error) {
+	var node blockNode
+	value, err := get(nodeKey(hash))
+	if err != nil {
+		return node, err
+	}
+	err = gob.NewDecoder(bytes.NewReader(value)).Decode(&node)
+	return node, err
+}
+
+func setStatus(batch Batch, hash []byte, status BlockStatus) error {
+	node, err := readNode(batch.Get, hash)
+	if err != nil {
+		return err
+	}
+	node.Status = status
+	return batch.Put(nodeKey(hash), node.Serialize())
+}
+
+// storeBlock adds a block to the tree of known blocks without connecting
+// it: it records the work of the chain ending at the block, as weighed by
+// engine, and makes the block a chain tip in place of its parent.
+func storeBlock(batch Batch, block *Block, engine ConsensusEngine) error {
+	work := engine.Weight(block)
+	if len(block.PreviousHash) > 0 {
+		parent, err := readNode(batch.Get, block.PreviousHash)
+		if err != nil {
+			return fmt.Errorf("block %x: parent %x: %w", block.Hash, block.PreviousHash, err)
+		}
+		work.Add(work, new(big.Int).SetBytes(parent.Work))
+		if err := batch.Delete(chainTipKey(block.PreviousHash)); err != nil {
+			return err
+		}
+	}
+	if err := batch.PutBlock(block); err != nil {
+		return err
+	}
+	if err := batch.Put(nodeKey(block.Hash), blockNode{work.Bytes(), StatusHeaderValid}.Serialize()); err != nil {
+		return err
+	}
+	return batch.Put(chainTipKey(block.Hash), []byte{1})
+}
+
+// disconnectBlock takes the tip block off the main chain, undoing
+// everything connectBlock did apart from storing the block.
+func disconnectBlock(batch Batch, block *Block) error {
+	if block.IsPruned() {
+		return fmt.Errorf("Cannot disconnect block %d: %w", block.Height, ErrPruned)
+	}
+	if err := unindexTransactions(batch, block); err != nil {
+		return err
+	}
+	if err := revertBlock(batch, block); err != nil {
+		return err
+	}
+	if err := batch.Delete(heightKey(block.Height)); err != nil {
+		return err
+	}
+	return batch.SetTip(block.PreviousHash)
+}
+
+func onMainChain(get func(key []byte) ([]byte, error), block *Block) (bool, error) {
+	hash, err := get(heightKey(block.Height))
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return bytes.Equal(hash, block.Hash), err
+}
+
+// branchOf returns the blocks leading from the main chain to hash, oldest
+// first. It is empty when hash is itself on the main chain.
+func branchOf(get func(key []byte) ([]byte, error), hash []byte) ([]*Block, error) {
+	var branch []*Block
+	for {
+		block, err := readBlock(get, hash)
+		if err != nil {
+			return nil, err
+		}
+		main, err := onMainChain(get, block)
+		if err != nil {
+			return nil, err
+		}
+		if main {
+			break
+		}
+		branch = append([]*Block{block}, branch...)
+		hash = block.PreviousHash
+	}
+	return branch, nil
+}
+
+// unspentOutputs looks outputs up in the UTXO set read through get.
+func unspentOutputs(get func(key []byte) ([]byte, error)) outputLookup {
+	return func(ID []byte, out int) (outputEntry, error) {
+		value, err := get(utxoKey(ID, out))
+		if err != nil {
+			return outputEntry{}, err
+		}
+		coinbaseHeight, err := readCoinbaseHeight(get, ID)
+		if err != nil {
+			return outputEntry{}, err
+		}
+		return outputEntry{DeserializeOutput(value), coinbaseHeight}, nil
+	}
+}
+
+// connectBatchSize is how many blocks of a branch extending the tip are
+// connected in one batch.
+const connectBatchSize = 100
+
+// reorganize makes target the tip: it disconnects the main chain back to
+// where target's branch leaves it and connects the branch instead, all in
+// one batch. A branch that simply extends the tip, such as an imported
+// chain, is connected connectBatchSize blocks at a time instead, so that
+// long ones fit in a batch. A branch block that breaks a rule aborts the
+// switch with a *BlockError naming it and wrapping the *RuleError. Branches
+// may not leave the main chain below a checkpoint it has passed, and the
+// signatures of blocks the last checkpoint builds on are taken on trust.
+func (chain *BlockChain) reorganize(target []byte) error {
+	branch, err := branchOf(chain.Database.Get, target)
+	if err != nil {
+		return err
+	}
+	if len(branch) == 0 {
+		return fmt.Errorf("block %x is already on the main chain", target)
+	}
+	trusted, err := chain.params().checkpointedBlocks(chain.Database.Get, branch[0].Height)
+	if err != nil {
+		return err
+	}
+
+	size := len(branch)
+	if bytes.Equal(branch[0].PreviousHash, chain.LastHash) && size > connectBatchSize {
+		size = connectBatchSize
+	}
+	for start := 0; start < len(branch); start += size {
+		end := start + size
+		if end > len(branch) {
+			end = len(branch)
+		}
+		if err := chain.connectBranch(branch[start:end], trusted); err != nil {
+			return err
+		}
+		chain.LastHash = branch[end-1].Hash
+	}
+	return nil
+}
+
+// connectBranch disconnects the main chain back to the parent of the first
+// block of branch and connects branch in its place, in one batch. The
+// signatures of the blocks in trusted are not checked.
+func (chain *BlockChain) connectBranch(branch []*Block, trusted map[string]bool) error {
+	return chain.Database.Update(func(batch Batch) error {
+		if err := checkTip(batch, chain.LastHash); err != nil {
+			return err
+		}
+		tipBlock, err := readBlock(batch.Get, chain.LastHash)
+		if err != nil {
+			return err
+		}
+		if err := chain.params().checkCheckpointFork(branch[0], tipBlock.Height); err != nil {
+			return &BlockError{branch[0].Height, branch[0].Hash, err}
+		}
+
+		tip := chain.LastHash
+		for !bytes.Equal(tip, branch[0].PreviousHash) {
+			block, err := readBlock(batch.Get, tip)
+			if err != nil {
+				return err
+			}
+			if err := disconnectBlock(batch, block); err != nil {
+				return err
+			}
+			tip = block.PreviousHash
+		}
+
+		for _, block := range branch {
+			err := checkCheckpoint(block, chain.params().checkpoint(block.Height))
+			if err == nil {
+				signatures := !trusted[string(block.Hash)]
+				err = checkBlockTransactions(block, chain.params(), signatures, chain.engine(), unspentOutputs(batch.Get))
+			}
+			if err == nil {
+				err = connectBlock(batch, block)
+			}
+			var rule *RuleError
+			if errors.As(err, &rule) {
+				return &BlockError{block.Height, block.Hash, err}
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// activateBestChain switches to the valid chain tip with the most work, if
+// that is not the current one. Branches whose blocks turn out to be invalid
+// are marked so and the next best tip is tried. The first rule violation
+// found is returned once the chain has settled.
+func (chain *BlockChain) activateBestChain() error {
+	var violation error
+	for {
+		best, err := chain.bestTip()
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(best, chain.LastHash) {
+			return violation
+		}
+
+		err = chain.reorganize(best)
+		var invalid *BlockError
+		if errors.As(err, &invalid) {
+			if err := chain.markInvalid(invalid.Hash); err != nil {
+				return err
+			}
+			if violation == nil {
+				violation = err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (chain *BlockChain) bestTip() ([]byte, error) {
+	tips, err := chain.ChainTips()
+	if err != nil {
+		return nil, err
+	}
+	best := chain.LastHash
+	var bestWork *big.Int
+	for _, tip := range tips {
+		if tip.Status == TipActive {
+			bestWork = tip.Work
+		}
+	}
+	for _, tip := range tips {
+		if tip.Status != TipInvalid && tip.Work.Cmp(bestWork) > 0 {
+			best, bestWork = tip.Hash, tip.Work
+		}
+	}
+	return best, nil
+}
+
+// markInvalid marks a block and every known block built on it as invalid.
+func (chain *BlockChain) markInvalid(hash []byte) error {
+	bad, err := chain.GetBlock(hash)
+	if err != nil {
+		return err
+	}
+	invalid := [][]byte{hash}
+
+	tips, err := chain.tipHashes()
+	if err != nil {
+		return err
+	}
+	for _, tip := range tips {
+		var path [][]byte
+		block, err := chain.GetBlock(tip)
+		if err != nil {
+			return err
+		}
+		for block.Height > bad.Height {
+			path = append(path, block.Hash)
+			block, err = chain.GetBlock(block.PreviousHash)
+			if err != nil {
+				return err
+			}
+		}
+		if bytes.Equal(block.Hash, hash) {
+			invalid = append(invalid, path...)
+		}
+	}
+
+	return chain.Database.Update(func(batch Batch) error {
+		for _, hash := range invalid {
+			if err := setStatus(batch, hash, StatusInvalid); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Chain tip statuses, as reported by ChainTips.
+const (
+	TipActive       = "active"
+	TipValidFork    = "valid-fork"
+	TipValidHeaders = "valid-headers"
+	TipInvalid      = "invalid"
+)
+
+type ChainTip struct {
+	Height int
+	Hash   []byte
+	// BranchLength is the number of blocks from the main chain to the tip.
+	BranchLength int
+	Work         *big.Int
+	Status       string
+}
+
+func (chain *BlockChain) tipHashes() ([][]byte, error) {
+	var hashes [][]byte
+	err := chain.Database.Iterate(chainTipPrefix, false, func(key, value []byte) bool {
+		hashes = append(hashes, append([]byte{}, key[len(chainTipPrefix):]...))
+		return true
+	})
+	return hashes, err
+}
+
+// ChainTips lists the blocks no known block builds on, and the main chain
+// tip, highest first.
+func (chain *BlockChain) ChainTips() ([]ChainTip, error) {
+	hashes, err := chain.tipHashes()
+	if err != nil {
+		return nil, err
+	}
+	active := false
+	for _, hash := range hashes {
+		active = active || bytes.Equal(hash, chain.LastHash)
+	}
+	if !active {
+		hashes = append(hashes, chain.LastHash)
+	}
+
+	var tips []ChainTip
+	for _, hash := range hashes {
+		block, err := chain.GetBlock(hash)
+		if err != nil {
+			return nil, err
+		}
+		node, err := readNode(chain.Database.Get, hash)
+		if err != nil {
+			return nil, fmt.Errorf("block %x: %w", hash, err)
+		}
+		tip := ChainTip{block.Height, hash, 0, new(big.Int).SetBytes(node.Work), TipActive}
+
+		if !bytes.Equal(hash, chain.LastHash) {
+			tip.Status = TipValidFork
+			branch, err := branchOf(chain.Database.Get, hash)
+			if err != nil {
+				return nil, err
+			}
+			tip.BranchLength = len(branch)
+			for _, block := range branch {
+				node, err := readNode(chain.Database.Get, block.Hash)
+				if err != nil {
+					return nil, err
+				}
+				if node.Status == StatusInvalid {
+					tip.Status = TipInvalid
+					break
+				}
+				if node.Status == StatusHeaderValid {
+					tip.Status = TipValidHeaders
+				}
+			}
+		}
+		tips = append(tips, tip)
+	}
+
+	sort.Slice(tips, func(i, j int) bool { return tips[i].Height > tips[j].Height })
+	return tips, nil
+}
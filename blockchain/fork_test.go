@@ -0,0 +1,71 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// checkUTXOSet compares the UTXO set the chain kept up to date block by
+// block with one rebuilt from scratch.
+func checkUTXOSet(t *testing.T, chain *BlockChain) {
+	t.Helper()
+	kept := dumpStore(t, chain.Database, utxoPrefix, false)
+	UTXOSet{chain}.Reindex()
+	if rebuilt := dumpStore(t, chain.Database, utxoPrefix, false); fmt.Sprint(kept) != fmt.Sprint(rebuilt) {
+		t.Errorf("UTXO set after reorganizing is %v, rebuilt from the blocks it is %v", kept, rebuilt)
+	}
+}
+
+func TestReorganizeRestoresUTXOSet(t *testing.T) {
+	chain, owner := newTestChain(t, &RegtestParams)
+	address := string(owner.Address())
+	genesis := mustBlock(t, chain, chain.LastHash)
+	payment := spend(genesis.Transactions[0], 0, owner, owner, testAddress())
+
+	a1 := sealBlock(t, chain, genesis, address, payment)
+	if err := chain.AcceptBlock(a1); err != nil {
+		t.Fatal(err)
+	}
+	b1 := sealBlock(t, chain, genesis, testAddress())
+	b2 := sealBlock(t, chain, b1, testAddress())
+	for _, block := range []*Block{b1, b2} {
+		if err := chain.AcceptBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(chain.LastHash, b2.Hash) {
+		t.Fatalf("tip is %x, want the longer branch ending in %x", chain.LastHash, b2.Hash)
+	}
+
+	// The output a1 spent is back, and what a1 created is gone.
+	if _, err := chain.Database.Get(utxoKey(genesis.Transactions[0].ID, 0)); err != nil {
+		t.Errorf("genesis coinbase output after disconnecting its spend: %v", err)
+	}
+	for _, tx := range a1.Transactions {
+		if _, err := chain.Database.Get(utxoKey(tx.ID, 0)); !errors.Is(err, ErrNotFound) {
+			t.Errorf("output of disconnected transaction %x returned %v, want %v", tx.ID, err, ErrNotFound)
+		}
+	}
+	checkUTXOSet(t, chain)
+
+	// Switching back to the first branch spends the genesis coinbase again.
+	a2 := sealBlock(t, chain, a1, address)
+	a3 := sealBlock(t, chain, a2, address)
+	for _, block := range []*Block{a2, a3} {
+		if err := chain.AcceptBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !bytes.Equal(chain.LastHash, a3.Hash) {
+		t.Fatalf("tip is %x, want %x", chain.LastHash, a3.Hash)
+	}
+	if _, err := chain.Database.Get(utxoKey(genesis.Transactions[0].ID, 0)); !errors.Is(err, ErrNotFound) {
+		t.Errorf("genesis coinbase output after reconnecting its spend returned %v, want %v", err, ErrNotFound)
+	}
+	if _, err := chain.Database.Get(utxoKey(payment.ID, 0)); err != nil {
+		t.Errorf("payment output after reconnecting it: %v", err)
+	}
+	checkUTXOSet(t, chain)
+}
@@ -0,0 +1,120 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// legacyTypes are the gob type definitions the first release sent ahead of
+// every transaction it hashed. Gob numbers types in the order a process
+// first encodes them, and Go releases since have numbered them differently,
+// so the encoding is rebuilt here rather than left to encoding/gob.
+var legacyTypes, _ = hex.DecodeString("" +
+	"39ff810301010b5472616e73616374696f6e01ff8200010301024944010a0001" +
+	"06496e7075747301ff860001074f75747075747301ff8a00000023ff85020101" +
+	"145b5d626c6f636b636861696e2e5478496e70757401ff860001ff8400003dff" +
+	"83030101075478496e70757401ff8400010401024944010a0001034f75740104" +
+	"0001095369676e6174757265010a0001065075624b6579010a00000024ff8902" +
+	"0101155b5d626c6f636b636861696e2e54784f757470757401ff8a0001ff8800" +
+	"002fff870301010854784f757470757401ff88000102010556616c7565010400" +
+	"010a5075624b657948617368010a000000")
+
+// legacyTransactionType is the gob type ID the first release gave
+// Transaction.
+const legacyTransactionType = 65
+
+// legacyHash is how the first release hashed transactions: a gob encoding
+// of everything but the ID. It hashed them before signing them, so the
+// signatures are left out too. The IDs and signatures of transactions in
+// blocks older than merkleBlockVersion were made with it.
+func (tx *Transaction) legacyHash() []byte {
+	var value bytes.Buffer
+	writeGobInt(&value, legacyTransactionType)
+
+	// Gob writes the non-zero fields of a struct, each preceded by how far
+	// it is from the previous one, and ends the struct with a zero.
+	if len(tx.Inputs) > 0 {
+		writeGobUint(&value, 2)
+		writeGobUint(&value, uint64(len(tx.Inputs)))
+		for _, in := range tx.Inputs {
+			last := -1
+			field := func(number int) {
+				writeGobUint(&value, uint64(number-last))
+				last = number
+			}
+			if len(in.ID) > 0 {
+				field(0)
+				writeGobBytes(&value, in.ID)
+			}
+			if in.Out != 0 {
+				field(1)
+				writeGobInt(&value, int64(in.Out))
+			}
+			if len(in.PubKey) > 0 {
+				field(3)
+				writeGobBytes(&value, in.PubKey)
+			}
+			value.WriteByte(0)
+		}
+	}
+	if len(tx.Outputs) > 0 {
+		if len(tx.Inputs) > 0 {
+			writeGobUint(&value, 1)
+		} else {
+			writeGobUint(&value, 3)
+		}
+		writeGobUint(&value, uint64(len(tx.Outputs)))
+		for _, out := range tx.Outputs {
+			last := -1
+			if out.Value != 0 {
+				writeGobUint(&value, 1)
+				writeGobInt(&value, int64(out.Value))
+				last = 0
+			}
+			if len(out.PubKeyHash) > 0 {
+				writeGobUint(&value, uint64(1-last))
+				writeGobBytes(&value, out.PubKeyHash)
+			}
+			value.WriteByte(0)
+		}
+	}
+	value.WriteByte(0)
+
+	var encoded bytes.Buffer
+	encoded.Write(legacyTypes)
+	writeGobUint(&encoded, uint64(value.Len()))
+	encoded.Write(value.Bytes())
+
+	hash := sha256.Sum256(encoded.Bytes())
+	return hash[:]
+}
+
+// writeGobUint writes x in one byte if it is small, or as its negated byte
+// count followed by its big endian bytes.
+func writeGobUint(buffer *bytes.Buffer, x uint64) {
+	if x < 0x80 {
+		buffer.WriteByte(byte(x))
+		return
+	}
+	var digits []byte
+	for ; x > 0; x >>= 8 {
+		digits = append([]byte{byte(x)}, digits...)
+	}
+	buffer.WriteByte(byte(-len(digits)))
+	buffer.Write(digits)
+}
+
+// writeGobInt folds the sign of x into the lowest bit.
+func writeGobInt(buffer *bytes.Buffer, x int64) {
+	if x < 0 {
+		writeGobUint(buffer, uint64(^x)<<1|1)
+		return
+	}
+	writeGobUint(buffer, uint64(x)<<1)
+}
+
+func writeGobBytes(buffer *bytes.Buffer, data []byte) {
+	writeGobUint(buffer, uint64(len(data)))
+	buffer.Write(data)
+}
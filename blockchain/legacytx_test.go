@@ -0,0 +1,97 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/gustavoddoki/GoBlockchain/wallet"
+)
+
+func TestLegacyHashMatchesFirstRelease(t *testing.T) {
+	store := loadFixture(t, "schema0-three.txt")
+	chain := LoadBlockChain(store)
+
+	for iter := chain.Iterator(); ; {
+		block := iter.Next()
+		for i, tx := range block.Transactions {
+			if !bytes.Equal(tx.legacyHash(), tx.ID) {
+				t.Errorf("block %d transaction %d: legacy hash %x, ID %x", block.Height, i, tx.legacyHash(), tx.ID)
+			}
+			if bytes.Equal(tx.Hash(), tx.ID) {
+				t.Errorf("block %d transaction %d: current hash matches the legacy ID", block.Height, i)
+			}
+		}
+		if len(block.PreviousHash) == 0 {
+			break
+		}
+	}
+}
+
+func TestLegacyChainVerifiesAndReimports(t *testing.T) {
+	chain := LoadBlockChain(loadFixture(t, "schema0-three.txt"))
+	for level := 1; level <= 3; level++ {
+		if _, err := chain.VerifyChain(level); err != nil {
+			t.Fatalf("level %d: %v", level, err)
+		}
+	}
+
+	var file bytes.Buffer
+	if _, err := chain.ExportChain(&file); err != nil {
+		t.Fatal(err)
+	}
+	exported := file.Bytes()
+	if _, _, err := ImportChain(NewMemoryStore(), &MainParams, bytes.NewReader(exported)); !errors.Is(err, ErrBadVersion) {
+		t.Errorf("importing without a legacy height got %v, want %v", err, ErrBadVersion)
+	}
+	params := MainParams
+	params.LegacyHeight = 3
+	imported, count, err := ImportChain(NewMemoryStore(), &params, bytes.NewReader(exported))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 || !bytes.Equal(imported.LastHash, chain.LastHash) {
+		t.Errorf("imported %d blocks up to %x, want 3 up to %x", count, imported.LastHash, chain.LastHash)
+	}
+}
+
+func TestLegacyShortPublicKeyVerifies(t *testing.T) {
+	// The first release stored X and Y without padding, so a key with a
+	// leading zero byte in either is 63 bytes long and halving it splits
+	// a short Y in the wrong place.
+	for _, short := range []string{"X", "Y"} {
+		t.Run(short, func(t *testing.T) {
+			var key *ecdsa.PrivateKey
+			for key == nil || len(key.X.Bytes())+len(key.Y.Bytes()) != 63 || (len(key.X.Bytes()) == 31) != (short == "X") {
+				var err error
+				if key, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader); err != nil {
+					t.Fatal(err)
+				}
+			}
+			pubKey := append(key.X.Bytes(), key.Y.Bytes()...)
+
+			prev := Transaction{[]byte("previous"), nil, []TxOutput{{10, wallet.PublicKeyHash(pubKey)}}}
+			tx := Transaction{nil, []TxInput{{prev.ID, 0, nil, pubKey}}, []TxOutput{*NewTXOutput(10, testAddress())}}
+			txCopy := tx.TrimmedCopy()
+			txCopy.Inputs[0].PubKey = prev.Outputs[0].PubKeyHash
+			r, s, err := ecdsa.Sign(rand.Reader, key, txCopy.legacyHash())
+			if err != nil {
+				t.Fatal(err)
+			}
+			tx.Inputs[0].Signature = append(r.Bytes(), s.Bytes()...)
+
+			prevTXs := map[string]Transaction{hex.EncodeToString(prev.ID): prev}
+			if !tx.verify(prevTXs, 0) {
+				t.Errorf("legacy transaction signed with a key of short %s does not verify", short)
+			}
+			tx.Outputs[0].Value = 9
+			if tx.verify(prevTXs, 0) {
+				t.Error("altered legacy transaction verifies")
+			}
+		})
+	}
+}
@@ -0,0 +1,81 @@
+package blockchain
+
+import (
+	"encoding/binary"
+	"errors"
+	"log"
+)
+
+// outputEntry is an output along with the height of the block whose
+// coinbase created it, or -1 if a regular transaction did.
+type outputEntry struct {
+	TxOutput
+	CoinbaseHeight int
+}
+
+type outputLookup func(ID []byte, out int) (outputEntry, error)
+
+// isMature tells whether an output created by the coinbase of the block at
+// coinbaseHeight, or -1 for any other transaction, can be spent in the
+// block at height. Coinbases must be maturity blocks deep, so that coins
+// minted on a branch that is later abandoned cannot have moved on.
+//
+// The genesis coinbase is exempt on purpose: no branch can abandon it, and
+// a proof of stake chain has nothing else to stake its first blocks with.
+func isMature(coinbaseHeight int, height int, maturity int) bool {
+	return coinbaseHeight <= 0 || height-coinbaseHeight >= maturity
+}
+
+func coinbaseKey(txID []byte) []byte {
+	return append(append([]byte{}, coinbasePrefix...), txID...)
+}
+
+// putCoinbaseHeight records that txID is the coinbase of the block at
+// height. The record stays after its outputs are spent and only goes away
+// when the block is disconnected.
+func putCoinbaseHeight(batch Batch, txID []byte, height int) error {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(height))
+	return batch.Put(coinbaseKey(txID), value)
+}
+
+// readCoinbaseHeight returns the height of the block txID is the coinbase
+// of, or -1 if it is not a coinbase.
+func readCoinbaseHeight(get func(key []byte) ([]byte, error), txID []byte) (int, error) {
+	value, err := get(coinbaseKey(txID))
+	if errors.Is(err, ErrNotFound) {
+		return -1, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint64(value)), nil
+}
+
+// Balance returns the value of the unspent outputs locked with pubKeyHash,
+// split into what can be spent in the next block and coinbase outputs that
+// are not deep enough yet.
+func (u UTXOSet) Balance(pubKeyHash []byte) (spendable int, immature int) {
+	height := u.Blockchain.GetBestHeight() + 1
+	err := u.Blockchain.Database.Iterate(utxoPrefix, false, func(key, value []byte) bool {
+		out := DeserializeOutput(value)
+		if !out.IsLockedWithKey(pubKeyHash) {
+			return true
+		}
+		txID, _ := splitUtxoKey(key)
+		coinbaseHeight, err := readCoinbaseHeight(u.Blockchain.Database.Get, txID)
+		if err != nil {
+			log.Panic(err)
+		}
+		if isMature(coinbaseHeight, height, u.Blockchain.params().CoinbaseMaturity) {
+			spendable += out.Value
+		} else {
+			immature += out.Value
+		}
+		return true
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return spendable, immature
+}
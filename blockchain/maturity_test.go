@@ -0,0 +1,60 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/gustavoddoki/GoBlockchain/wallet"
+)
+
+func TestCoinbaseMaturity(t *testing.T) {
+	params := RegtestParams
+	params.CoinbaseMaturity = 3
+	chain, owner := newTestChain(t, &params)
+	address := string(owner.Address())
+	genesis := mustBlock(t, chain, chain.LastHash)
+
+	// The genesis coinbase can be spent straight away.
+	block1 := sealBlock(t, chain, genesis, address, spend(genesis.Transactions[0], 0, owner, owner, testAddress()))
+	if err := chain.AcceptBlock(block1); err != nil {
+		t.Fatalf("spending the genesis coinbase in block 1: %v", err)
+	}
+
+	tx := spend(block1.Transactions[0], 0, owner, owner, testAddress())
+	for height := 2; height <= 1+params.CoinbaseMaturity; height++ {
+		tip := mustBlock(t, chain, chain.LastHash)
+		err := chain.ValidateBlock(sealBlock(t, chain, tip, address, tx))
+		if height-1 < params.CoinbaseMaturity {
+			if !errors.Is(err, ErrImmatureSpend) {
+				t.Errorf("spending the coinbase of block 1 in block %d got %v, want %v", height, err, ErrImmatureSpend)
+			}
+		} else if err != nil {
+			t.Errorf("spending the coinbase of block 1 in block %d: %v", height, err)
+		}
+		if err := chain.AcceptBlock(sealBlock(t, chain, tip, address)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// At height 5 the coinbases of blocks 1 and 2 have matured and those of
+	// blocks 3 and 4 have not.
+	spendable, immature := UTXOSet{chain}.Balance(wallet.PublicKeyHash(owner.PublicKey))
+	if spendable != params.subsidy(1)+params.subsidy(2) || immature != params.subsidy(3)+params.subsidy(4) {
+		t.Errorf("balance is %d spendable and %d immature", spendable, immature)
+	}
+}
+
+func TestMaturityAboveLegacyHeight(t *testing.T) {
+	chain := LoadBlockChain(loadFixture(t, "schema0-three.txt"))
+	owner := wallet.CreateNewWallet()
+	block := sealBlock(t, chain, mustBlock(t, chain, chain.LastHash), string(owner.Address()))
+	if err := chain.AcceptBlock(block); err != nil {
+		t.Fatal(err)
+	}
+
+	tx := spend(block.Transactions[0], 0, owner, owner, testAddress())
+	err := chain.ValidateBlock(sealBlock(t, chain, block, testAddress(), tx))
+	if !errors.Is(err, ErrImmatureSpend) {
+		t.Errorf("spending the coinbase of the first block above the legacy height got %v, want %v", err, ErrImmatureSpend)
+	}
+}
@@ -0,0 +1,132 @@
+package blockchain
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// MemoryStore keeps the whole chain in memory. It is meant for tests and
+// for library users that need a throwaway chain.
+type MemoryStore struct {
+	mu      sync.RWMutex
+	writeMu sync.Mutex
+	data    map[string][]byte
+}
+
+type memoryBatch struct {
+	store   *MemoryStore
+	writes  map[string][]byte
+	deletes map[string]bool
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: make(map[string][]byte)}
+}
+
+func (store *MemoryStore) Get(key []byte) ([]byte, error) {
+	store.mu.RLock()
+	defer store.mu.RUnlock()
+
+	value, ok := store.data[string(key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return append([]byte{}, value...), nil
+}
+
+func (store *MemoryStore) GetBlock(hash []byte) (*Block, error) {
+	encoded_block, err := store.Get(blockKey(hash))
+	if err != nil {
+		return nil, err
+	}
+	return DeserializeBlock(encoded_block)
+}
+
+func (store *MemoryStore) GetTip() ([]byte, error) {
+	return store.Get(tipKey)
+}
+
+func (store *MemoryStore) Iterate(prefix []byte, reverse bool, fn func(key, value []byte) bool) error {
+	store.mu.RLock()
+	var keys []string
+	for key := range store.data {
+		if strings.HasPrefix(key, string(prefix)) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		values[i] = append([]byte{}, store.data[key]...)
+	}
+	store.mu.RUnlock()
+
+	for i := range keys {
+		if reverse {
+			i = len(keys) - 1 - i
+		}
+		if !fn([]byte(keys[i]), values[i]) {
+			break
+		}
+	}
+	return nil
+}
+
+func (store *MemoryStore) Update(fn func(batch Batch) error) error {
+	store.writeMu.Lock()
+	defer store.writeMu.Unlock()
+
+	batch := &memoryBatch{store, make(map[string][]byte), make(map[string]bool)}
+	if err := fn(batch); err != nil {
+		return err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+	for key := range batch.deletes {
+		delete(store.data, key)
+	}
+	for key, value := range batch.writes {
+		store.data[key] = value
+	}
+	return nil
+}
+
+func (store *MemoryStore) Close() error {
+	return nil
+}
+
+func (batch *memoryBatch) Get(key []byte) ([]byte, error) {
+	if batch.deletes[string(key)] {
+		return nil, ErrNotFound
+	}
+	if value, ok := batch.writes[string(key)]; ok {
+		return append([]byte{}, value...), nil
+	}
+	return batch.store.Get(key)
+}
+
+func (batch *memoryBatch) Put(key []byte, value []byte) error {
+	delete(batch.deletes, string(key))
+	batch.writes[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (batch *memoryBatch) Delete(key []byte) error {
+	delete(batch.writes, string(key))
+	batch.deletes[string(key)] = true
+	return nil
+}
+
+func (batch *memoryBatch) PutBlock(block *Block) error {
+	return batch.Put(blockKey(block.Hash), block.Serialize())
+}
+
+func (batch *memoryBatch) GetTip() ([]byte, error) {
+	return batch.Get(tipKey)
+}
+
+func (batch *memoryBatch) SetTip(hash []byte) error {
+	return batch.Put(tipKey, hash)
+}
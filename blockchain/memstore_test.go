@@ -0,0 +1,144 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// dumpStore lists every key and value under prefix in iteration order.
+func dumpStore(t *testing.T, store ChainStore, prefix []byte, reverse bool) []string {
+	t.Helper()
+	var entries []string
+	err := store.Iterate(prefix, reverse, func(key, value []byte) bool {
+		entries = append(entries, fmt.Sprintf("%x=%x", key, value))
+		return true
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entries
+}
+
+func TestMemoryStoreMatchesBadgerStore(t *testing.T) {
+	badgerStore, err := OpenBadgerStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer badgerStore.Close()
+	stores := map[string]ChainStore{"memory": NewMemoryStore(), "badger": badgerStore}
+
+	block := newBlock([]*Transaction{CreateCoinbaseTx(testAddress(), "", 10)}, nil, 0, BigToCompact(MainParams.powLimit()), 1)
+	block.Hash = []byte("block")
+	errDiscard := errors.New("discard")
+	updates := []struct {
+		fn  func(batch Batch) error
+		err error
+	}{
+		{func(batch Batch) error {
+			for _, key := range []string{"a/1", "a/2", "a/3", "b/1", "a"} {
+				if err := batch.Put([]byte(key), []byte("v"+key)); err != nil {
+					return err
+				}
+			}
+			if err := batch.PutBlock(block); err != nil {
+				return err
+			}
+			return batch.SetTip(block.Hash)
+		}, nil},
+		// A batch reads its own writes and deletes.
+		{func(batch Batch) error {
+			if err := batch.Delete([]byte("a/2")); err != nil {
+				return err
+			}
+			if _, err := batch.Get([]byte("a/2")); !errors.Is(err, ErrNotFound) {
+				return fmt.Errorf("deleted key read back with %v", err)
+			}
+			if err := batch.Put([]byte("a/1"), []byte("new")); err != nil {
+				return err
+			}
+			if value, err := batch.Get([]byte("a/1")); err != nil || string(value) != "new" {
+				return fmt.Errorf("written key read back as %q, %v", value, err)
+			}
+			if tip, err := batch.GetTip(); err != nil || !bytes.Equal(tip, block.Hash) {
+				return fmt.Errorf("tip read back as %x, %v", tip, err)
+			}
+			return nil
+		}, nil},
+		// A failed batch leaves the store untouched.
+		{func(batch Batch) error {
+			if err := batch.Put([]byte("a/4"), []byte("lost")); err != nil {
+				return err
+			}
+			if err := batch.Delete([]byte("a/3")); err != nil {
+				return err
+			}
+			return errDiscard
+		}, errDiscard},
+	}
+	for name, store := range stores {
+		for i, update := range updates {
+			if err := store.Update(update.fn); !errors.Is(err, update.err) {
+				t.Fatalf("%s: update %d returned %v, want %v", name, i, err, update.err)
+			}
+		}
+	}
+
+	memory := stores["memory"]
+	want := []string{
+		fmt.Sprintf("%x=%x", "a/1", "new"),
+		fmt.Sprintf("%x=%x", "a/3", "va/3"),
+	}
+	for _, reverse := range []bool{false, true} {
+		got := dumpStore(t, memory, []byte("a/"), reverse)
+		if reverse {
+			want[0], want[1] = want[1], want[0]
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("memory store iterated %v in reverse %v, want %v", got, reverse, want)
+		}
+		if other := dumpStore(t, badgerStore, []byte("a/"), reverse); fmt.Sprint(got) != fmt.Sprint(other) {
+			t.Errorf("iterating in reverse %v: memory store has %v, badger store %v", reverse, got, other)
+		}
+	}
+	if got, other := dumpStore(t, memory, nil, false), dumpStore(t, badgerStore, nil, false); fmt.Sprint(got) != fmt.Sprint(other) {
+		t.Errorf("memory store holds %v, badger store %v", got, other)
+	}
+
+	for name, store := range stores {
+		if _, err := store.Get([]byte("a/2")); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: deleted key returned %v, want %v", name, err, ErrNotFound)
+		}
+		if _, err := store.GetBlock([]byte("missing")); !errors.Is(err, ErrNotFound) {
+			t.Errorf("%s: missing block returned %v, want %v", name, err, ErrNotFound)
+		}
+		if tip, err := store.GetTip(); err != nil || !bytes.Equal(tip, block.Hash) {
+			t.Errorf("%s: tip is %x, %v", name, tip, err)
+		}
+		stored, err := store.GetBlock(block.Hash)
+		if err != nil || !bytes.Equal(stored.Serialize(), block.Serialize()) {
+			t.Errorf("%s: block read back differently, %v", name, err)
+		}
+	}
+}
+
+func TestGetBlockReportsCorruptBlocks(t *testing.T) {
+	badgerStore, err := OpenBadgerStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer badgerStore.Close()
+
+	for name, store := range map[string]ChainStore{"memory": NewMemoryStore(), "badger": badgerStore} {
+		err := store.Update(func(batch Batch) error {
+			return batch.Put(blockKey([]byte("corrupt")), []byte("not a block"))
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if block, err := store.GetBlock([]byte("corrupt")); err == nil {
+			t.Errorf("%s: corrupt block read back as %v", name, block)
+		}
+	}
+}
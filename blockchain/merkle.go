@@ -0,0 +1,166 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// Leaves and inner nodes are hashed with different prefixes so that an inner
+// node can never be passed off as a transaction. A node without a sibling is
+// carried up to the next level unchanged.
+const (
+	merkleLeafPrefix = 0x00
+	merkleNodePrefix = 0x01
+)
+
+func merkleLeaf(txID []byte) []byte {
+	hash := sha256.Sum256(append([]byte{merkleLeafPrefix}, txID...))
+	return hash[:]
+}
+
+func merkleParent(left, right []byte) []byte {
+	data := append(append([]byte{merkleNodePrefix}, left...), right...)
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
+
+// merkleLevels returns every level of the tree over txIDs, leaves first and
+// the root last.
+func merkleLevels(txIDs [][]byte) [][][]byte {
+	level := make([][]byte, len(txIDs))
+	for i, id := range txIDs {
+		level[i] = merkleLeaf(id)
+	}
+	levels := [][][]byte{level}
+
+	for len(level) > 1 {
+		var next [][]byte
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+			} else {
+				next = append(next, merkleParent(level[i], level[i+1]))
+			}
+		}
+		levels = append(levels, next)
+		level = next
+	}
+	return levels
+}
+
+// MerkleRoot returns the root of the Merkle tree whose leaves are txIDs.
+func MerkleRoot(txIDs [][]byte) []byte {
+	if len(txIDs) == 0 {
+		hash := sha256.Sum256(nil)
+		return hash[:]
+	}
+	levels := merkleLevels(txIDs)
+	return levels[len(levels)-1][0]
+}
+
+// MerkleStep is one sibling on the path from a transaction to the root.
+type MerkleStep struct {
+	Hash []byte
+	Left bool
+}
+
+// MerkleProof shows that transaction TxID is committed to by Header. The
+// header has its transactions stripped and its Merkle root in TxRoot, so a
+// proof can be checked without the rest of the block.
+type MerkleProof struct {
+	TxID   []byte
+	Header Block
+	Steps  []MerkleStep
+}
+
+func buildMerkleSteps(txIDs [][]byte, index int) []MerkleStep {
+	var steps []MerkleStep
+	levels := merkleLevels(txIDs)
+	for _, level := range levels[:len(levels)-1] {
+		sibling := index ^ 1
+		if sibling < len(level) {
+			steps = append(steps, MerkleStep{level[sibling], sibling < index})
+		}
+		index /= 2
+	}
+	return steps
+}
+
+// Root returns the Merkle root the proof leads to.
+func (proof *MerkleProof) Root() []byte {
+	hash := merkleLeaf(proof.TxID)
+	for _, step := range proof.Steps {
+		if step.Left {
+			hash = merkleParent(step.Hash, hash)
+		} else {
+			hash = merkleParent(hash, step.Hash)
+		}
+	}
+	return hash
+}
+
+// Verify checks that the proof leads to the Merkle root of its header and
+// that the header is sealed the way engine requires. It does not tell
+// whether the header belongs to any particular chain.
+func (proof *MerkleProof) Verify(engine ConsensusEngine) error {
+	header := &proof.Header
+	if header.Version < merkleBlockVersion {
+		return fmt.Errorf("block %x predates Merkle roots", header.Hash)
+	}
+	if !bytes.Equal(proof.Root(), header.TransactionsHash()) {
+		return fmt.Errorf("transaction %x is not committed to by block %x", proof.TxID, header.Hash)
+	}
+	hash := sha256.Sum256(CreateProofOfWork(header).ProcessData(header.Nonce))
+	if !bytes.Equal(hash[:], header.Hash) {
+		return fmt.Errorf("block %x does not match its header hash %x", header.Hash, hash)
+	}
+	return engine.VerifyHeader(header)
+}
+
+// TransactionProof builds an inclusion proof for the transaction with the
+// given ID.
+func (chain *BlockChain) TransactionProof(ID []byte) (*MerkleProof, error) {
+	_, block, err := chain.FindTransactionBlock(ID)
+	if err != nil {
+		return nil, err
+	}
+	if block.Version < merkleBlockVersion {
+		return nil, fmt.Errorf("block %d predates Merkle roots, no proof can be built", block.Height)
+	}
+
+	var txIDs [][]byte
+	index := -1
+	for i, tx := range block.Transactions {
+		txIDs = append(txIDs, tx.ID)
+		if bytes.Equal(tx.ID, ID) {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil, errors.New("Transaction does not exist")
+	}
+
+	return &MerkleProof{ID, *block.Header(), buildMerkleSteps(txIDs, index)}, nil
+}
+
+func (proof *MerkleProof) Serialize() []byte {
+	var result bytes.Buffer
+	err := gob.NewEncoder(&result).Encode(proof)
+	if err != nil {
+		log.Panic(err)
+	}
+	return result.Bytes()
+}
+
+func DeserializeMerkleProof(data []byte) (*MerkleProof, error) {
+	var proof MerkleProof
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&proof)
+	if err != nil {
+		return nil, err
+	}
+	return &proof, nil
+}
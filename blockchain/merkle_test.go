@@ -0,0 +1,76 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestMerkleStepsLeadToRoot(t *testing.T) {
+	for count := 1; count <= 7; count++ {
+		var txIDs [][]byte
+		for i := 0; i < count; i++ {
+			txIDs = append(txIDs, []byte(fmt.Sprintf("tx%d", i)))
+		}
+		root := MerkleRoot(txIDs)
+		for index, id := range txIDs {
+			proof := MerkleProof{id, Block{}, buildMerkleSteps(txIDs, index)}
+			if !bytes.Equal(proof.Root(), root) {
+				t.Errorf("transaction %d of %d leads to %x, want the root %x", index, count, proof.Root(), root)
+			}
+		}
+	}
+}
+
+func TestTransactionProofRoundTrip(t *testing.T) {
+	chain, owner := newTestChain(t, &RegtestParams)
+	address := string(owner.Address())
+	genesis := mustBlock(t, chain, chain.LastHash)
+	value := genesis.Transactions[0].Outputs[0].Value
+	payment := spendTo(genesis.Transactions[0], 0, owner, owner, *NewTXOutput(value/2, address), *NewTXOutput(value-value/2, address))
+	change := spend(payment, 0, owner, owner, testAddress())
+	block := sealBlock(t, chain, genesis, address, payment, change)
+	if err := chain.AcceptBlock(block); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tx := range block.Transactions {
+		proof, err := chain.TransactionProof(tx.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		received, err := DeserializeMerkleProof(proof.Serialize())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := received.Verify(Miner{}); err != nil {
+			t.Errorf("proof of transaction %x: %v", tx.ID, err)
+		}
+	}
+
+	proof, err := chain.TransactionProof(change.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := map[string]func(proof *MerkleProof){
+		"other transaction": func(proof *MerkleProof) { proof.TxID = genesis.Transactions[0].ID },
+		"sibling hash":      func(proof *MerkleProof) { proof.Steps[0].Hash = merkleLeaf(genesis.Transactions[0].ID) },
+		"sibling side":      func(proof *MerkleProof) { proof.Steps[0].Left = !proof.Steps[0].Left },
+		"missing step":      func(proof *MerkleProof) { proof.Steps = proof.Steps[1:] },
+		"header root":       func(proof *MerkleProof) { proof.Header.TxRoot = MerkleRoot([][]byte{proof.TxID}) },
+		"header time":       func(proof *MerkleProof) { proof.Header.CreationTime++ },
+		"legacy header":     func(proof *MerkleProof) { proof.Header.Version = 0 },
+	}
+	for name, tamper := range tampered {
+		t.Run(name, func(t *testing.T) {
+			received, err := DeserializeMerkleProof(proof.Serialize())
+			if err != nil {
+				t.Fatal(err)
+			}
+			tamper(received)
+			if err := received.Verify(Miner{}); err == nil {
+				t.Error("tampered proof verified")
+			}
+		})
+	}
+}
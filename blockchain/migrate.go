@@ -0,0 +1,395 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+)
+
+type migration struct {
+	version     int
+	description string
+	apply       func(store ChainStore) (string, error)
+}
+
+// migrations upgrade a database one schema version at a time. Each one must
+// be safe to run again if it was interrupted, since the schema version is
+// only bumped after it completes.
+var migrations = []migration{
+	{1, "move keys into namespaced prefixes", migrateNamespaces},
+	{2, "number blocks stored without a height", migrateHeights},
+	{3, "record chain work and undo data", migrateChainWork},
+	{4, "record coinbase heights", migrateCoinbaseHeights},
+	{5, "record the legacy height", migrateLegacyHeight},
+}
+
+var CurrentSchemaVersion = migrations[len(migrations)-1].version
+
+const migrationBatchSize = 1000
+
+func ReadSchemaVersion(store ChainStore) (int, error) {
+	value, err := store.Get(schemaKey)
+	if err == nil {
+		return int(binary.BigEndian.Uint64(value)), nil
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return 0, err
+	}
+	if _, err := store.Get([]byte("lh")); err == nil {
+		return 0, nil
+	}
+	return 0, errors.New("Database has no schema version and no legacy chain tip")
+}
+
+func writeSchemaVersion(batch Batch, version int) error {
+	value := make([]byte, 8)
+	binary.BigEndian.PutUint64(value, uint64(version))
+	return batch.Put(schemaKey, value)
+}
+
+// Migrate upgrades store to the current schema and returns a line for every
+// migration it ran. With dryRun the migrations run against an in-memory copy
+// of store, which is left untouched, so the report shows exactly what a real
+// run would do.
+func Migrate(store ChainStore, dryRun bool) ([]string, error) {
+	version, err := ReadSchemaVersion(store)
+	if err != nil {
+		return nil, err
+	}
+	if version > CurrentSchemaVersion {
+		return nil, fmt.Errorf("Database schema version %d is newer than the supported version %d", version, CurrentSchemaVersion)
+	}
+	if version == CurrentSchemaVersion {
+		return nil, nil
+	}
+
+	target := store
+	if dryRun {
+		target, err = copyToMemory(store)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var report []string
+	for _, m := range migrations {
+		if m.version <= version {
+			continue
+		}
+		summary, err := m.apply(target)
+		if err != nil {
+			return report, fmt.Errorf("migration to schema version %d (%s): %v", m.version, m.description, err)
+		}
+		err = target.Update(func(batch Batch) error {
+			return writeSchemaVersion(batch, m.version)
+		})
+		if err != nil {
+			return report, err
+		}
+		report = append(report, fmt.Sprintf("schema %d -> %d, %s: %s", m.version-1, m.version, m.description, summary))
+	}
+	return report, nil
+}
+
+func copyToMemory(store ChainStore) (*MemoryStore, error) {
+	clone := NewMemoryStore()
+	err := clone.Update(func(batch Batch) error {
+		var putErr error
+		err := store.Iterate(nil, false, func(key, value []byte) bool {
+			putErr = batch.Put(key, value)
+			return putErr == nil
+		})
+		if err != nil {
+			return err
+		}
+		return putErr
+	})
+	return clone, err
+}
+
+type keyMove struct {
+	from []byte
+	to   []byte
+}
+
+func moveKeys(store ChainStore, moves []keyMove) error {
+	for start := 0; start < len(moves); start += migrationBatchSize {
+		end := start + migrationBatchSize
+		if end > len(moves) {
+			end = len(moves)
+		}
+		err := store.Update(func(batch Batch) error {
+			for _, move := range moves[start:end] {
+				value, err := batch.Get(move.from)
+				if err != nil {
+					return err
+				}
+				if err := batch.Put(move.to, value); err != nil {
+					return err
+				}
+				if err := batch.Delete(move.from); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Schema 0 is the layout of the first release: blocks under their bare hash
+// and the tip under "lh". Block hashes always start with a zero byte because
+// of the proof of work, so they cannot be mistaken for the prefixed keys.
+func migrateNamespaces(store ChainStore) (string, error) {
+	var moves []keyMove
+	blocks, unknown := 0, 0
+	err := store.Iterate(nil, false, func(key, value []byte) bool {
+		if string(key) == "lh" {
+			moves = append(moves, keyMove{key, tipKey})
+			return true
+		}
+		if len(key) == 32 && key[0] == 0 {
+			moves = append(moves, keyMove{key, blockKey(key)})
+			blocks++
+			return true
+		}
+		if !bytes.Contains(key, []byte("/")) {
+			unknown++
+		}
+		return true
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if err := moveKeys(store, moves); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("moved %d blocks and the tip, %d unrecognised keys left in place", blocks, unknown), nil
+}
+
+// Blocks written before heights were recorded all decode with height 0.
+// Number them from the tip back and index their heights, and drop the index
+// markers so the indexes that copy heights are rebuilt on the next start.
+func migrateHeights(store ChainStore) (string, error) {
+	var chain []*Block
+	hash, err := store.GetTip()
+	if err != nil {
+		return "", err
+	}
+	for {
+		data, err := store.Get(blockKey(hash))
+		if err != nil {
+			return "", fmt.Errorf("block %x: %v", hash, err)
+		}
+		block, err := DeserializeBlock(data)
+		if err != nil {
+			return "", fmt.Errorf("block %x: %v", hash, err)
+		}
+		chain = append(chain, block)
+		if len(block.PreviousHash) == 0 {
+			break
+		}
+		hash = block.PreviousHash
+	}
+
+	renumbered := make(map[*Block]bool)
+	for i, block := range chain {
+		height := len(chain) - 1 - i
+		if block.Height != height {
+			block.Height = height
+			renumbered[block] = true
+		}
+	}
+	for start := 0; start < len(chain); start += migrationBatchSize {
+		end := start + migrationBatchSize
+		if end > len(chain) {
+			end = len(chain)
+		}
+		err := store.Update(func(batch Batch) error {
+			for _, block := range chain[start:end] {
+				if renumbered[block] {
+					if err := batch.PutBlock(block); err != nil {
+						return err
+					}
+				}
+				if err := batch.Put(heightKey(block.Height), block.Hash); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	if len(renumbered) == 0 {
+		return fmt.Sprintf("all %d blocks already numbered", len(chain)), nil
+	}
+
+	err = store.Update(func(batch Batch) error {
+		if err := batch.Delete(utxoTipKey); err != nil {
+			return err
+		}
+		return batch.Delete(txIndexTipKey)
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("renumbered %d of %d blocks", len(renumbered), len(chain)), nil
+}
+
+// Blocks written before forks were tracked have no recorded work and the
+// UTXO set kept no undo data. Record the work of the main chain, make its
+// tip the only chain tip and drop the UTXO marker so the set, and the undo
+// data with it, is rebuilt from the blocks on the next start. A pruned chain
+// cannot be replayed, so its older blocks stay impossible to disconnect.
+func migrateChainWork(store ChainStore) (string, error) {
+	var chain []*Block
+	hash, err := store.GetTip()
+	if err != nil {
+		return "", err
+	}
+	for {
+		block, err := store.GetBlock(hash)
+		if err != nil {
+			return "", fmt.Errorf("block %x: %v", hash, err)
+		}
+		chain = append(chain, block)
+		if len(block.PreviousHash) == 0 {
+			break
+		}
+		hash = block.PreviousHash
+	}
+
+	work := new(big.Int)
+	for start := len(chain) - 1; start >= 0; start -= migrationBatchSize {
+		end := start - migrationBatchSize
+		if end < -1 {
+			end = -1
+		}
+		err := store.Update(func(batch Batch) error {
+			for i := start; i > end; i-- {
+				work.Add(work, chain[i].Work())
+				node := blockNode{work.Bytes(), StatusValid}
+				if err := batch.Put(nodeKey(chain[i].Hash), node.Serialize()); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+
+	pruned := chain[len(chain)-1].IsPruned()
+	err = store.Update(func(batch Batch) error {
+		if err := batch.Put(chainTipKey(chain[0].Hash), []byte{1}); err != nil {
+			return err
+		}
+		if pruned {
+			return nil
+		}
+		return batch.Delete(utxoTipKey)
+	})
+	if err != nil {
+		return "", err
+	}
+	if pruned {
+		return fmt.Sprintf("recorded work for %d blocks, the chain is pruned so blocks connected before now cannot be disconnected", len(chain)), nil
+	}
+	return fmt.Sprintf("recorded work for %d blocks, the UTXO set will be rebuilt to record undo data", len(chain)), nil
+}
+
+// Coinbase maturity needs the height every coinbase was minted at. Record
+// it for the main chain blocks that still have their transactions; the
+// coinbases of pruned blocks are left out and count as mature.
+func migrateCoinbaseHeights(store ChainStore) (string, error) {
+	var chain []*Block
+	hash, err := store.GetTip()
+	if err != nil {
+		return "", err
+	}
+	for {
+		block, err := store.GetBlock(hash)
+		if err != nil {
+			return "", fmt.Errorf("block %x: %v", hash, err)
+		}
+		chain = append(chain, block)
+		if len(block.PreviousHash) == 0 {
+			break
+		}
+		hash = block.PreviousHash
+	}
+
+	recorded := 0
+	for start := 0; start < len(chain); start += migrationBatchSize {
+		end := start + migrationBatchSize
+		if end > len(chain) {
+			end = len(chain)
+		}
+		err := store.Update(func(batch Batch) error {
+			for _, block := range chain[start:end] {
+				for _, tx := range block.Transactions {
+					if !tx.FlagCoinbaseTx() {
+						continue
+					}
+					if err := putCoinbaseHeight(batch, tx.ID, block.Height); err != nil {
+						return err
+					}
+					recorded++
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return "", err
+		}
+	}
+	return fmt.Sprintf("recorded %d coinbase heights", recorded), nil
+}
+
+// The first release stored no version in its blocks, which decode as
+// version 0, and none of the rules that came with later versions applied to
+// them. Record how many of them the chain starts with in its parameters, so
+// that they stay exempt because of their height and no later block can be
+// exempted by claiming to be one of them.
+func migrateLegacyHeight(store ChainStore) (string, error) {
+	params, err := readParams(store.Get)
+	if err != nil {
+		return "", err
+	}
+	hash, err := store.GetTip()
+	if err != nil {
+		return "", err
+	}
+	legacyHeight := 0
+	for {
+		block, err := store.GetBlock(hash)
+		if err != nil {
+			return "", fmt.Errorf("block %x: %v", hash, err)
+		}
+		if block.Version == 0 && legacyHeight == 0 {
+			legacyHeight = block.Height + 1
+		}
+		if len(block.PreviousHash) == 0 {
+			break
+		}
+		hash = block.PreviousHash
+	}
+
+	params.LegacyHeight = legacyHeight
+	err = store.Update(func(batch Batch) error {
+		return batch.Put(paramsKey, params.Serialize())
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("the first %d blocks were mined by the first release", legacyHeight), nil
+}
@@ -0,0 +1,143 @@
+package blockchain
+
+import (
+	"bufio"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// loadFixture fills a MemoryStore with the keys and values listed in a
+// testdata file, one hex encoded pair per line. The schema0 fixtures were
+// dumped from data directories written by the program before any
+// migration existed.
+func loadFixture(t *testing.T, name string) *MemoryStore {
+	t.Helper()
+	file, err := os.Open(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	store := NewMemoryStore()
+	err = store.Update(func(batch Batch) error {
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			key, err := hex.DecodeString(fields[0])
+			if err != nil {
+				return err
+			}
+			value, err := hex.DecodeString(fields[1])
+			if err != nil {
+				return err
+			}
+			if err := batch.Put(key, value); err != nil {
+				return err
+			}
+		}
+		return scanner.Err()
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return store
+}
+
+func TestMigrateSchema0(t *testing.T) {
+	tests := []struct {
+		fixture string
+		blocks  int
+	}{
+		{"schema0-one.txt", 1},
+		{"schema0-three.txt", 3},
+	}
+	for _, test := range tests {
+		t.Run(test.fixture, func(t *testing.T) {
+			store := loadFixture(t, test.fixture)
+			if version, err := ReadSchemaVersion(store); err != nil || version != 0 {
+				t.Fatalf("fixture has schema version %d, %v", version, err)
+			}
+
+			if _, err := Migrate(store, false); err != nil {
+				t.Fatal(err)
+			}
+			if version, err := ReadSchemaVersion(store); err != nil || version != CurrentSchemaVersion {
+				t.Fatalf("migrated to schema version %d, %v", version, err)
+			}
+
+			for height := 0; height < test.blocks; height++ {
+				hash, err := store.Get(heightKey(height))
+				if err != nil {
+					t.Fatalf("height %d: %v", height, err)
+				}
+				block, err := store.GetBlock(hash)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if block.Height != height {
+					t.Errorf("block indexed at height %d has height %d", height, block.Height)
+				}
+				for _, tx := range block.Transactions {
+					if !tx.FlagCoinbaseTx() {
+						continue
+					}
+					coinbaseHeight, err := readCoinbaseHeight(store.Get, tx.ID)
+					if err != nil || coinbaseHeight != height {
+						t.Errorf("coinbase of block %d recorded at height %d, %v", height, coinbaseHeight, err)
+					}
+				}
+			}
+
+			chain := LoadBlockChain(store)
+			if height := chain.GetBestHeight(); height != test.blocks-1 {
+				t.Errorf("best height %d, want %d", height, test.blocks-1)
+			}
+			if chain.params().LegacyHeight != test.blocks {
+				t.Errorf("legacy height %d, want %d", chain.params().LegacyHeight, test.blocks)
+			}
+		})
+	}
+}
+
+func TestMigrateNamespacesKeepsUnknownKeys(t *testing.T) {
+	store := loadFixture(t, "schema0-one.txt")
+	tip, err := store.Get([]byte("lh"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Keys the first release never wrote are not guessed at.
+	unknown := []string{"utxo-x", "bh-0", "opt-txindex"}
+	err = store.Update(func(batch Batch) error {
+		for _, key := range unknown {
+			if err := batch.Put([]byte(key), []byte("v")); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := migrateNamespaces(store); err != nil {
+		t.Fatal(err)
+	}
+	if moved, err := store.GetTip(); err != nil || string(moved) != string(tip) {
+		t.Errorf("tip moved as %x, %v, want %x", moved, err, tip)
+	}
+	if _, err := store.GetBlock(tip); err != nil {
+		t.Errorf("tip block: %v", err)
+	}
+	for _, key := range unknown {
+		if value, err := store.Get([]byte(key)); err != nil || string(value) != "v" {
+			t.Errorf("%s read back as %q, %v", key, value, err)
+		}
+	}
+	if _, err := store.Get(append(append([]byte{}, utxoPrefix...), 'x')); err == nil {
+		t.Error("utxo-x was moved into the UTXO set")
+	}
+}
@@ -0,0 +1,145 @@
+package blockchain
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"math"
+	"math/big"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// hashBatch is how many nonces a worker tries between checking for
+// cancellation and publishing its hash count.
+const hashBatch = 1 << 12
+
+// Miner is the proof of work consensus engine and holds the settings used
+// to mine blocks. The zero value mines on every CPU and reports nothing.
+type Miner struct {
+	// Threads is the number of workers searching the nonce space. Zero or
+	// less means runtime.NumCPU().
+	Threads int
+	// Hashrate, if set, is called about once a second while mining and once
+	// more when a block is found, with the hashes per second achieved so far.
+	Hashrate func(hashesPerSecond float64)
+}
+
+// Seal searches for a nonce that gives block a hash below its target and
+// stores both in block. It gives up with the context's error once ctx is
+// done.
+func (miner Miner) Seal(ctx context.Context, block *Block) error {
+	nonce, hash, err := CreateProofOfWork(block).Mine(ctx, miner.Threads, miner.Hashrate)
+	if err != nil {
+		return err
+	}
+	block.Nonce = nonce
+	block.Hash = hash
+	return nil
+}
+
+// Mine splits the nonce space between threads workers, worker i trying
+// nonces i, i+threads, i+2*threads and so on, and returns the first nonce
+// found to meet the target.
+func (pow *ProofOfWork) Mine(ctx context.Context, threads int, report func(float64)) (int, []byte, error) {
+	if threads <= 0 {
+		threads = runtime.NumCPU()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type solution struct {
+		nonce int
+		hash  []byte
+	}
+	header, offset := pow.headerData()
+	found := make(chan solution, threads)
+	done := make(chan struct{})
+	var hashes int64
+	var wg sync.WaitGroup
+
+	for i := 0; i < threads; i++ {
+		wg.Add(1)
+		go func(start int) {
+			defer wg.Done()
+			var hashInt big.Int
+			data := append([]byte{}, header...)
+			count := int64(0)
+			defer func() { atomic.AddInt64(&hashes, count) }()
+
+			for nonce := start; nonce <= math.MaxInt64-threads; nonce += threads {
+				if count == hashBatch {
+					atomic.AddInt64(&hashes, count)
+					count = 0
+					if ctx.Err() != nil {
+						return
+					}
+				}
+				putNonce(data, offset, nonce)
+				hash := sha256.Sum256(data)
+				count++
+				hashInt.SetBytes(hash[:])
+				if hashInt.Cmp(pow.Target) == -1 {
+					found <- solution{nonce, hash[:]}
+					return
+				}
+			}
+		}(i)
+	}
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	started := time.Now()
+	hashrate := func() {
+		if report != nil {
+			report(float64(atomic.LoadInt64(&hashes)) / time.Since(started).Seconds())
+		}
+	}
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			hashrate()
+		case <-done:
+			select {
+			case s := <-found:
+				hashrate()
+				return s.nonce, s.hash, nil
+			default:
+			}
+			if err := ctx.Err(); err != nil {
+				return 0, nil, err
+			}
+			return 0, nil, errors.New("No nonce meets the target")
+		case s := <-found:
+			cancel()
+			<-done
+			hashrate()
+			return s.nonce, s.hash, nil
+		}
+	}
+}
+
+// NextBits retargets every retargetInterval blocks.
+func (miner Miner) NextBits(store ChainStore, params *ChainParams, parent *Block) (uint32, error) {
+	return nextBits(store, params, parent)
+}
+
+// VerifyHeader checks that the block hash is below the block's target.
+func (miner Miner) VerifyHeader(block *Block) error {
+	if !CreateProofOfWork(block).Validate() {
+		return ruleError(ErrBadProofOfWork, "block %x does not meet the proof of work target", block.Hash)
+	}
+	return nil
+}
+
+// Weight is the expected number of hashes it took to mine the block.
+func (miner Miner) Weight(block *Block) *big.Int {
+	return block.Work()
+}
@@ -0,0 +1,59 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// minerBlock returns an unsealed block with target bits.
+func minerBlock(bits uint32) *Block {
+	return newBlock([]*Transaction{CreateCoinbaseTx(testAddress(), "", 10)}, []byte("parent"), 1, bits, 1700000000)
+}
+
+func TestMinerSealsWithThreads(t *testing.T) {
+	bits := BigToCompact(new(big.Int).Lsh(big.NewInt(1), 244))
+	for _, threads := range []int{1, 4} {
+		t.Run(fmt.Sprintf("%d threads", threads), func(t *testing.T) {
+			block := minerBlock(bits)
+			if err := (Miner{Threads: threads}).Seal(context.Background(), block); err != nil {
+				t.Fatal(err)
+			}
+			if err := (Miner{}).VerifyHeader(block); err != nil {
+				t.Error(err)
+			}
+			// The nonce the workers found hashes to the same header as one
+			// built from scratch.
+			if hash := sha256.Sum256(CreateProofOfWork(block).ProcessData(block.Nonce)); !bytes.Equal(hash[:], block.Hash) {
+				t.Errorf("block hash is %x, its header hashes to %x", block.Hash, hash)
+			}
+		})
+	}
+}
+
+func TestMinerStopsWhenCancelled(t *testing.T) {
+	// No hash is below a target of one.
+	bits := BigToCompact(big.NewInt(1))
+	for _, threads := range []int{1, 4} {
+		t.Run(fmt.Sprintf("%d threads", threads), func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+			defer cancel()
+			started := time.Now()
+			block := minerBlock(bits)
+			if err := (Miner{Threads: threads}).Seal(ctx, block); !errors.Is(err, context.DeadlineExceeded) {
+				t.Fatalf("sealing returned %v, want %v", err, context.DeadlineExceeded)
+			}
+			if elapsed := time.Since(started); elapsed > 5*time.Second {
+				t.Errorf("sealing took %s to stop", elapsed)
+			}
+			if len(block.Hash) != 0 {
+				t.Errorf("cancelled block was given hash %x", block.Hash)
+			}
+		})
+	}
+}
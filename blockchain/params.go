@@ -0,0 +1,251 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"os"
+
+	"github.com/gustavoddoki/GoBlockchain/wallet"
+)
+
+// ChainParams are the settings a network is launched with. Every node of a
+// network must use the same ones; they are stored with the chain when it is
+// created.
+type ChainParams struct {
+	// Name tells networks apart in data directories and error messages.
+	Name string `json:"name"`
+	// Magic starts the bootstrap files of the network.
+	Magic uint32 `json:"magic"`
+	// GenesisMessage is the data of the genesis coinbase.
+	GenesisMessage string `json:"genesisMessage"`
+	// GenesisTime stamps the genesis block, in seconds since the Unix epoch.
+	// Zero stamps it with the time the chain is created.
+	GenesisTime int64 `json:"genesisTime"`
+	// Premine is paid by the genesis coinbase on top of its subsidy. It does
+	// not count towards the supply cap of the reward schedule.
+	Premine []Allocation   `json:"premine"`
+	Rewards RewardSchedule `json:"rewards"`
+	// CoinbaseMaturity is how many blocks deep a coinbase must be before
+	// its outputs can be spent.
+	CoinbaseMaturity int `json:"coinbaseMaturity"`
+	// TargetBlockTime is the number of seconds a block should take,
+	// which the target is retargeted towards.
+	TargetBlockTime int64 `json:"targetBlockTime"`
+	// Difficulty is the number of leading zero bits of the easiest proof of
+	// work target, which the genesis block is mined at.
+	Difficulty int `json:"difficulty"`
+	// NoRetarget keeps the target of every block at that of the genesis
+	// block.
+	NoRetarget bool `json:"noRetarget"`
+	// AddressVersion is the first byte of the network's addresses.
+	AddressVersion byte `json:"addressVersion"`
+	// Consensus names the engine of the network: "pow", "poa" or "pos".
+	// Empty means proof of work.
+	Consensus string `json:"consensus"`
+	// Authorities are the addresses taking turns to sign the blocks of a
+	// proof of authority network.
+	Authorities []string `json:"authorities"`
+	// Checkpoints maps heights to the hex hashes the blocks at those heights
+	// must have. Branches that differ from them are rejected whatever their
+	// work, and once the block at the last one is known, the signatures of
+	// the blocks it builds on are not checked.
+	Checkpoints map[int]string `json:"checkpoints"`
+	// LegacyHeight is the number of blocks the chain starts with that were
+	// mined by the first release, which stored no version in them. They are
+	// held only to the rules of that release, and every later block must be
+	// of the current version. Migrating a data directory of the first
+	// release records it; other chains leave it at zero.
+	LegacyHeight int `json:"legacyHeight"`
+}
+
+// Allocation pays Amount coins to Address in the genesis block.
+type Allocation struct {
+	Address string `json:"address"`
+	Amount  int    `json:"amount"`
+}
+
+// MainParams are the parameters of the main network. Chains created before
+// parameters were stored use them, with the reward schedule they were
+// created with.
+var MainParams = ChainParams{
+	Name:             "main",
+	Magic:            0xe3c1a5f0,
+	GenesisMessage:   "First Transaction from Genesis",
+	Rewards:          DefaultRewardSchedule,
+	CoinbaseMaturity: 10,
+	TargetBlockTime:  60,
+	Difficulty:       legacyDifficulty,
+	AddressVersion:   0x00,
+}
+
+// RegtestParams are the parameters of a network for local testing. Blocks
+// are mined at a difficulty of one bit that never changes, so any number of
+// them can be generated on demand, and coinbases mature after two blocks.
+var RegtestParams = ChainParams{
+	Name:             "regtest",
+	Magic:            0xdab5bffa,
+	GenesisMessage:   "Regression test genesis",
+	Rewards:          DefaultRewardSchedule,
+	CoinbaseMaturity: 2,
+	TargetBlockTime:  60,
+	Difficulty:       1,
+	NoRetarget:       true,
+	AddressVersion:   0x6f,
+}
+
+// LoadChainParams reads parameters from a JSON file. Fields the file leaves
+// out keep their values from MainParams, except the name and magic, which
+// every file must give so that a private network is never mistaken for the
+// main one.
+func LoadChainParams(path string) (*ChainParams, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	params := MainParams
+	params.Name, params.Magic = "", 0
+	decoder := json.NewDecoder(file)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&params); err != nil {
+		return nil, fmt.Errorf("Reading chain parameters from %s: %w", path, err)
+	}
+	if params.Name == "" || params.Magic == 0 {
+		return nil, fmt.Errorf("Chain parameters in %s need both a name and a magic number", path)
+	}
+	if err := params.Validate(); err != nil {
+		return nil, err
+	}
+	return &params, nil
+}
+
+func (params *ChainParams) Validate() error {
+	if params.Name == "" {
+		return errors.New("Chain parameters need a network name")
+	}
+	if err := params.Rewards.Validate(); err != nil {
+		return err
+	}
+	if params.CoinbaseMaturity < 1 {
+		return fmt.Errorf("Coinbase maturity must be at least one block, got %d", params.CoinbaseMaturity)
+	}
+	if params.TargetBlockTime <= 0 {
+		return fmt.Errorf("Target block time must be positive, got %d", params.TargetBlockTime)
+	}
+	if params.Difficulty < 1 || params.Difficulty > 255 {
+		return fmt.Errorf("Difficulty must be between 1 and 255 bits, got %d", params.Difficulty)
+	}
+	supply := params.Rewards.MaxSupply
+	for _, allocation := range params.Premine {
+		if !wallet.ValidateAddressVersion(allocation.Address, params.AddressVersion) {
+			return fmt.Errorf("Premine address %q is not an address of this network", allocation.Address)
+		}
+		if allocation.Amount <= 0 {
+			return fmt.Errorf("Premine to %s must be positive, got %d", allocation.Address, allocation.Amount)
+		}
+		var ok bool
+		if supply, ok = addValue(supply, allocation.Amount); !ok {
+			return fmt.Errorf("Maximum supply and premine add up to more than %d", MaxMoney)
+		}
+	}
+	for _, authority := range params.Authorities {
+		if !wallet.ValidateAddressVersion(authority, params.AddressVersion) {
+			return fmt.Errorf("Authority %q is not an address of this network", authority)
+		}
+	}
+	if params.Consensus == authorityEngine && len(params.Authorities) == 0 {
+		return errors.New("A proof of authority network needs authorities")
+	}
+	if params.Consensus != authorityEngine && len(params.Authorities) > 0 {
+		return errors.New("Authorities are only used by proof of authority networks")
+	}
+	if _, err := params.Engine(); err != nil {
+		return err
+	}
+	if params.LegacyHeight < 0 {
+		return fmt.Errorf("Legacy height must not be negative, got %d", params.LegacyHeight)
+	}
+	for height, hash := range params.Checkpoints {
+		if err := validateCheckpoint(height, hash); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// powLimit is the easiest proof of work target of the network.
+func (params *ChainParams) powLimit() *big.Int {
+	return new(big.Int).Lsh(big.NewInt(1), uint(256-params.Difficulty))
+}
+
+// legacy tells whether the block at height was mined by the first release.
+func (params *ChainParams) legacy(height int) bool {
+	return height < params.LegacyHeight
+}
+
+// subsidy is what the coinbase of the block at height may mint: the block
+// subsidy, plus the premine for the genesis block.
+func (params *ChainParams) subsidy(height int) int {
+	subsidy := params.Rewards.Subsidy(height)
+	if height == 0 {
+		for _, allocation := range params.Premine {
+			subsidy += allocation.Amount
+		}
+	}
+	return subsidy
+}
+
+// genesisCoinbase pays the genesis subsidy to address and the premine to
+// its recipients.
+func (params *ChainParams) genesisCoinbase(address string) *Transaction {
+	coinbase := CreateCoinbaseTx(address, params.GenesisMessage, params.Rewards.Subsidy(0))
+	for _, allocation := range params.Premine {
+		coinbase.Outputs = append(coinbase.Outputs, *NewTXOutput(allocation.Amount, allocation.Address))
+	}
+	coinbase.SetID()
+	return coinbase
+}
+
+func (params *ChainParams) Serialize() []byte {
+	var buffer bytes.Buffer
+	err := gob.NewEncoder(&buffer).Encode(params)
+	if err != nil {
+		log.Panic(err)
+	}
+	return buffer.Bytes()
+}
+
+// readParams returns the parameters the chain was created with. Chains
+// created before the coinbase maturity was a parameter use that of
+// MainParams.
+func readParams(get func(key []byte) ([]byte, error)) (*ChainParams, error) {
+	value, err := get(paramsKey)
+	if errors.Is(err, ErrNotFound) {
+		params := MainParams
+		params.Rewards, err = readRewardSchedule(get)
+		return &params, err
+	}
+	if err != nil {
+		return nil, err
+	}
+	var params ChainParams
+	err = gob.NewDecoder(bytes.NewReader(value)).Decode(&params)
+	if params.CoinbaseMaturity == 0 {
+		params.CoinbaseMaturity = MainParams.CoinbaseMaturity
+	}
+	return &params, err
+}
+
+// params returns the chain's parameters, which default to MainParams.
+func (chain *BlockChain) params() *ChainParams {
+	if chain.Params != nil {
+		return chain.Params
+	}
+	return &MainParams
+}
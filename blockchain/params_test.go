@@ -0,0 +1,53 @@
+package blockchain
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadChainParams(t *testing.T) {
+	tests := []struct {
+		name string
+		json string
+		ok   bool
+	}{
+		{"name and magic", `{"name": "testnet", "magic": 185402615}`, true},
+		{"everything", `{"name": "testnet", "magic": 185402615, "genesisMessage": "Hello testnet", "genesisTime": 1700000000,
+			"rewards": {"initialSubsidy": 50, "halvingInterval": 1000, "maxSupply": 1000000},
+			"coinbaseMaturity": 5, "targetBlockTime": 30, "difficulty": 8, "addressVersion": 111}`, true},
+		{"no name", `{"magic": 185402615}`, false},
+		{"no magic", `{"name": "testnet"}`, false},
+		{"empty", `{}`, false},
+		{"unknown field", `{"name": "testnet", "magic": 185402615, "difficuty": 8}`, false},
+		{"not JSON", `name: testnet`, false},
+		{"zero difficulty", `{"name": "testnet", "magic": 185402615, "difficulty": 0}`, false},
+		{"difficulty above 255", `{"name": "testnet", "magic": 185402615, "difficulty": 256}`, false},
+		{"zero maturity", `{"name": "testnet", "magic": 185402615, "coinbaseMaturity": 0}`, false},
+		{"zero block time", `{"name": "testnet", "magic": 185402615, "targetBlockTime": 0}`, false},
+		{"supply above MaxMoney", `{"name": "testnet", "magic": 185402615, "rewards": {"initialSubsidy": 50, "maxSupply": 9007199254740993}}`, false},
+		{"premine to another network", `{"name": "testnet", "magic": 185402615, "addressVersion": 111,
+			"premine": [{"address": "` + testAddress() + `", "amount": 10}]}`, false},
+		{"negative premine", `{"name": "testnet", "magic": 185402615, "premine": [{"address": "` + testAddress() + `", "amount": -1}]}`, false},
+		{"unknown engine", `{"name": "testnet", "magic": 185402615, "consensus": "pow2"}`, false},
+		{"authority without authorities", `{"name": "testnet", "magic": 185402615, "consensus": "poa"}`, false},
+		{"authorities without authority", `{"name": "testnet", "magic": 185402615, "authorities": ["` + testAddress() + `"]}`, false},
+		{"negative legacy height", `{"name": "testnet", "magic": 185402615, "legacyHeight": -1}`, false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "params.json")
+			if err := os.WriteFile(path, []byte(test.json), 0644); err != nil {
+				t.Fatal(err)
+			}
+			params, err := LoadChainParams(path)
+			if test.ok != (err == nil) {
+				t.Fatalf("loading returned %v", err)
+			}
+			if test.ok && (params.Name != "testnet" || params.Magic != 185402615) {
+				t.Errorf("loaded network %q with magic %d", params.Name, params.Magic)
+			}
+		})
+	}
+}
@@ -4,23 +4,17 @@ import (
 	"bytes"
 	"crypto/sha256"
 	"encoding/binary"
-	"fmt"
 	"log"
-	"math"
 	"math/big"
 )
 
-const mining_difficulty = 12
-
 type ProofOfWork struct {
 	Block  *Block
 	Target *big.Int
 }
 
 func CreateProofOfWork(block *Block) *ProofOfWork {
-	target := big.NewInt(1)
-	target.Lsh(target, uint(256-mining_difficulty))
-	pow := &ProofOfWork{block, target}
+	pow := &ProofOfWork{block, block.Target()}
 	return pow
 }
 
@@ -34,40 +28,36 @@ func ConvertIntToHex(num int64) []byte {
 }
 
 func (pow *ProofOfWork) ProcessData(nonce int) []byte {
-	data := bytes.Join(
-		[][]byte{
-			pow.Block.PreviousHash,
-			pow.Block.HashTransactions(),
-			ConvertIntToHex(pow.Block.CreationTime),
-			ConvertIntToHex(int64(mining_difficulty)),
-			ConvertIntToHex(int64(nonce)),
-		},
-		[]byte{},
-	)
+	data, offset := pow.headerData()
+	putNonce(data, offset, nonce)
 	return data
 }
 
-func (pow *ProofOfWork) Run() (int, []byte) {
-	var hashInt big.Int
-	var hash [32]byte
-	nonce := 0
-
-	for nonce < math.MaxInt64 {
-		data := pow.ProcessData(nonce)
-		hash = sha256.Sum256(data)
-
-		fmt.Printf("\r%x", hash)
-		hashInt.SetBytes(hash[:])
-
-		if hashInt.Cmp(pow.Target) == -1 {
-			break
-		} else {
-			nonce++
-		}
+// headerData returns the hashed header with a zero nonce, and the offset of
+// the nonce in it, so that mining builds the header, and the transaction
+// root in it, once per block rather than once per nonce.
+func (pow *ProofOfWork) headerData() ([]byte, int) {
+	difficulty := int64(pow.Block.Bits)
+	if difficulty == 0 {
+		difficulty = legacyDifficulty
+	}
+	prefix := bytes.Join([][]byte{
+		pow.Block.PreviousHash,
+		pow.Block.TransactionsHash(),
+		ConvertIntToHex(pow.Block.CreationTime),
+		ConvertIntToHex(difficulty),
+	}, []byte{})
+	data := append(prefix, ConvertIntToHex(0)...)
+	if pow.Block.Version > 0 {
+		data = append(data, ConvertIntToHex(int64(pow.Block.Version))...)
 	}
-	fmt.Println()
+	return data, len(prefix)
+}
 
-	return nonce, hash[:]
+// putNonce writes nonce into header data at offset, the way ConvertIntToHex
+// encodes it.
+func putNonce(data []byte, offset int, nonce int) {
+	binary.BigEndian.PutUint64(data[offset:], uint64(nonce))
 }
 
 func (pow *ProofOfWork) Validate() bool {
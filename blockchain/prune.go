@@ -0,0 +1,118 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"log"
+)
+
+var ErrPruned = errors.New("Block data has been pruned")
+
+// PruneDepth is the number of most recent blocks that keep their
+// transactions. Zero means the chain is not pruned.
+func (chain *BlockChain) PruneDepth() int {
+	depth, err := readPruneDepth(chain.Database.Get)
+	if err != nil {
+		log.Panic(err)
+	}
+	return depth
+}
+
+func readPruneDepth(get func(key []byte) ([]byte, error)) (int, error) {
+	value, err := get(pruneDepthKey)
+	if errors.Is(err, ErrNotFound) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return int(binary.BigEndian.Uint64(value)), nil
+}
+
+// IsPruned reports whether any block has lost its transactions. Pruning
+// starts at genesis, so it is enough to look there.
+func (chain *BlockChain) IsPruned() bool {
+	genesis, err := chain.GetBlockByHeight(0)
+	if err != nil {
+		log.Panic(err)
+	}
+	return genesis.IsPruned()
+}
+
+// SetPruneDepth switches the chain to pruned mode: from now on only the
+// last depth blocks keep their transactions. Blocks already deeper than
+// that are pruned straight away. It returns how many blocks were pruned.
+func (chain *BlockChain) SetPruneDepth(depth int) (int, error) {
+	if depth <= 0 {
+		return 0, fmt.Errorf("Prune depth must be positive, got %d", depth)
+	}
+	applied, err := chain.Database.Get(utxoTipKey)
+	if err != nil || !bytes.Equal(applied, chain.LastHash) {
+		return 0, errors.New("Pruning needs an up to date UTXO set, run reindexutxo first")
+	}
+
+	err = chain.Database.Update(func(batch Batch) error {
+		value := make([]byte, 8)
+		binary.BigEndian.PutUint64(value, uint64(depth))
+		return batch.Put(pruneDepthKey, value)
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	pruned := 0
+	for height := chain.GetBestHeight() - depth - 1; height >= 0; height-- {
+		block, err := chain.GetBlockByHeight(height)
+		if err != nil {
+			return pruned, err
+		}
+		if block.IsPruned() {
+			break
+		}
+		err = chain.Database.Update(func(batch Batch) error {
+			return pruneBlock(batch, block)
+		})
+		if err != nil {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// pruneBlock also drops the block's undo data, since a block without its
+// transactions can no longer be disconnected anyway.
+func pruneBlock(batch Batch, block *Block) error {
+	if err := batch.Delete(undoKey(block.Hash)); err != nil {
+		return err
+	}
+	return batch.PutBlock(block.Header())
+}
+
+// pruneBehind drops the transactions of the block that tip pushed past the
+// prune depth, in the same batch that connects tip.
+func pruneBehind(batch Batch, tip *Block) error {
+	depth, err := readPruneDepth(batch.Get)
+	if err != nil || depth == 0 {
+		return err
+	}
+	height := tip.Height - depth - 1
+	if height < 0 {
+		return nil
+	}
+
+	hash, err := batch.Get(heightKey(height))
+	if err != nil {
+		return err
+	}
+	block, err := readBlock(batch.Get, hash)
+	if err != nil {
+		return err
+	}
+	if block.IsPruned() {
+		return nil
+	}
+	return pruneBlock(batch, block)
+}
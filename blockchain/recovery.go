@@ -0,0 +1,137 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// blocksSince returns the hashes of the blocks after from up to the tip,
+// oldest first. With a nil from it returns the whole chain. The boolean is
+// false when from is not an ancestor of the tip.
+func (chain *BlockChain) blocksSince(from []byte) ([][]byte, bool) {
+	var hashes [][]byte
+	hash := chain.LastHash
+	for !bytes.Equal(hash, from) {
+		block, err := chain.GetBlock(hash)
+		if err != nil {
+			log.Panic(err)
+		}
+		hashes = append(hashes, hash)
+		if len(block.PreviousHash) == 0 {
+			if from != nil {
+				return nil, false
+			}
+			break
+		}
+		hash = block.PreviousHash
+	}
+
+	for i, j := 0, len(hashes)-1; i < j; i, j = i+1, j-1 {
+		hashes[i], hashes[j] = hashes[j], hashes[i]
+	}
+	return hashes, true
+}
+
+// replay feeds the given blocks, in order, to apply, one batch per block.
+func (chain *BlockChain) replay(hashes [][]byte, apply func(batch Batch, block *Block) error) {
+	for _, hash := range hashes {
+		block, err := chain.GetBlock(hash)
+		if err != nil {
+			log.Panic(err)
+		}
+		if block.IsPruned() {
+			log.Panicf("Cannot replay block %d (%x): %s", block.Height, block.Hash, ErrPruned)
+		}
+		err = chain.Database.Update(func(batch Batch) error {
+			return apply(batch, block)
+		})
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+}
+
+func (chain *BlockChain) indexTip(key []byte) []byte {
+	hash, err := chain.Database.Get(key)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		log.Panic(err)
+	}
+	return hash
+}
+
+// recover checks that the tip block and the indexes agree and repairs
+// whatever a crash or an older version of the program left behind.
+func (chain *BlockChain) recover() {
+	tip, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		log.Panicf("Tip block %x is missing from the database: %s", chain.LastHash, err)
+	}
+
+	indexed, err := chain.GetBlockHash(tip.Height)
+	if err != nil || !bytes.Equal(indexed, tip.Hash) {
+		fmt.Println("Rebuilding the height index")
+		chain.reindexHeights()
+	}
+
+	applied := chain.indexTip(utxoTipKey)
+	if !bytes.Equal(applied, chain.LastHash) {
+		hashes, ok := chain.blocksSince(applied)
+		if applied == nil || !ok {
+			fmt.Println("Rebuilding the UTXO set")
+			UTXOSet{chain}.Reindex()
+		} else {
+			fmt.Printf("Applying %d blocks missing from the UTXO set\n", len(hashes))
+			chain.replay(hashes, applyBlock)
+		}
+	}
+
+	if chain.HasTxIndex() {
+		applied = chain.indexTip(txIndexTipKey)
+		if !bytes.Equal(applied, chain.LastHash) {
+			hashes, ok := chain.blocksSince(applied)
+			if applied == nil || !ok {
+				fmt.Println("Rebuilding the transaction index")
+				chain.ReindexTransactions()
+			} else {
+				fmt.Printf("Indexing %d blocks missing from the transaction index\n", len(hashes))
+				chain.replay(hashes, indexTransactions)
+			}
+		}
+	}
+}
+
+// reindexHeights indexes the main chain by walking back from the tip. It
+// only needs the block headers, so it works on a pruned chain too.
+func (chain *BlockChain) reindexHeights() {
+	hashes := chain.mustBlocksSince(nil)
+	for start := 0; start < len(hashes); start += migrationBatchSize {
+		end := start + migrationBatchSize
+		if end > len(hashes) {
+			end = len(hashes)
+		}
+		err := chain.Database.Update(func(batch Batch) error {
+			for height, hash := range hashes[start:end] {
+				if err := batch.Put(heightKey(start+height), hash); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			log.Panic(err)
+		}
+	}
+}
+
+func (chain *BlockChain) mustBlocksSince(from []byte) [][]byte {
+	hashes, ok := chain.blocksSince(from)
+	if !ok {
+		log.Panicf("Block %x is not part of the chain", from)
+	}
+	return hashes
+}
@@ -0,0 +1,95 @@
+package blockchain
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+func TestRecoverHeightsOnPrunedChain(t *testing.T) {
+	params := RegtestParams
+	chain, owner := newTestChain(t, &params)
+	for i := 0; i < 5; i++ {
+		if err := chain.AcceptBlock(sealBlock(t, chain, mustBlock(t, chain, chain.LastHash), string(owner.Address()))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := chain.SetPruneDepth(2); err != nil {
+		t.Fatal(err)
+	}
+	if !chain.IsPruned() {
+		t.Fatal("chain is not pruned")
+	}
+
+	if err := deleteByPrefix(chain.Database, heightPrefix); err != nil {
+		t.Fatal(err)
+	}
+	chain.recover()
+
+	hash := chain.LastHash
+	for height := 5; height >= 0; height-- {
+		indexed, err := chain.GetBlockHash(height)
+		if err != nil {
+			t.Fatalf("height %d: %v", height, err)
+		}
+		if !bytes.Equal(indexed, hash) {
+			t.Errorf("height %d indexes %x, want %x", height, indexed, hash)
+		}
+		hash = mustBlock(t, chain, hash).PreviousHash
+	}
+}
+
+func TestRecoverHalfAppliedBlock(t *testing.T) {
+	tests := []struct {
+		name string
+		undo func(batch Batch, block *Block) error
+	}{
+		{"indexes one block behind", func(batch Batch, block *Block) error {
+			if err := revertBlock(batch, block); err != nil {
+				return err
+			}
+			if err := unindexTransactions(batch, block); err != nil {
+				return err
+			}
+			return batch.Delete(heightKey(block.Height))
+		}},
+		{"index tips lost", func(batch Batch, block *Block) error {
+			if err := batch.Delete(utxoTipKey); err != nil {
+				return err
+			}
+			return batch.Delete(txIndexTipKey)
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chain, owner := newTestChain(t, &RegtestParams)
+			chain.ReindexTransactions()
+			address := string(owner.Address())
+			genesis := mustBlock(t, chain, chain.LastHash)
+			payment := spend(genesis.Transactions[0], 0, owner, owner, testAddress())
+			block := sealBlock(t, chain, genesis, address, payment)
+			if err := chain.AcceptBlock(block); err != nil {
+				t.Fatal(err)
+			}
+			want := dumpStore(t, chain.Database, nil, false)
+
+			// A crash between writing the block and updating what is
+			// derived from it leaves the tip ahead of the indexes.
+			if err := chain.Database.Update(func(batch Batch) error { return test.undo(batch, block) }); err != nil {
+				t.Fatal(err)
+			}
+			recovered := LoadBlockChain(chain.Database)
+
+			if !bytes.Equal(recovered.LastHash, block.Hash) {
+				t.Errorf("tip is %x, want %x", recovered.LastHash, block.Hash)
+			}
+			if got := dumpStore(t, recovered.Database, nil, false); fmt.Sprint(got) != fmt.Sprint(want) {
+				t.Errorf("recovered store holds\n%v\nwant\n%v", got, want)
+			}
+			if _, found, err := recovered.FindTransactionBlock(payment.ID); err != nil || !bytes.Equal(found.Hash, block.Hash) {
+				t.Errorf("payment found in block %v, %v", found, err)
+			}
+		})
+	}
+}
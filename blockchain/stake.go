@@ -0,0 +1,219 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"errors"
+	"log"
+	"math/big"
+
+	"github.com/gustavoddoki/GoBlockchain/wallet"
+)
+
+// stakeLimit is the easiest stake target, per coin staked. Outputs draw
+// once per 16-second slot, see stakeTimeMask.
+var stakeLimit = new(big.Int).Lsh(big.NewInt(1), 248)
+
+const (
+	// stakeTimeMask is cleared from the block time before it goes into the
+	// kernel hash, so an output gets one draw every 16 seconds whatever
+	// time the staker stamps its block with.
+	stakeTimeMask = 15
+	// maxFutureStakeTime is how far ahead of the local clock a proof of
+	// stake block may be stamped, in seconds.
+	maxFutureStakeTime = 15
+)
+
+var (
+	// ErrNoKernel is returned by Stake when none of the wallet's outputs
+	// may seal the next block at the current time.
+	ErrNoKernel = errors.New("No output may stake the next block yet")
+	// ErrNothingToStake is returned by Stake when the wallet has no mature
+	// outputs besides those the block's transactions spend.
+	ErrNothingToStake = errors.New("No mature outputs to stake")
+)
+
+// StakeEngine is a proof of stake: the second transaction of every block
+// after the genesis block is a coinstake that spends a mature output back to
+// its owner, and the block is signed by that owner. The kernel hash of the
+// staked output, which commits to the parent and the block time, must be
+// below the target times the output's value, so the chance of sealing a
+// block grows with the value staked. Targets retarget like proof of work
+// targets and blocks weigh the work their target stands for.
+type StakeEngine struct {
+	// Keys are the private keys this node stakes with. A node that only
+	// follows the chain needs none.
+	Keys []ecdsa.PrivateKey
+}
+
+// stakeChecker is implemented by engines that check a block against the
+// outputs it spends, on top of its header.
+type stakeChecker interface {
+	checkStake(block *Block, lookup outputLookup) error
+}
+
+func (engine StakeEngine) NextBits(store ChainStore, params *ChainParams, parent *Block) (uint32, error) {
+	return retarget(store, params, parent, stakeLimit)
+}
+
+// Seal signs the block with the key that owns its coinstake. The genesis
+// block has no coinstake and is signed with the first key.
+func (engine StakeEngine) Seal(ctx context.Context, block *Block) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(engine.Keys) == 0 {
+		return ruleError(ErrBadSeal, "block %d cannot be signed without a staking key", block.Height)
+	}
+	if block.Height == 0 {
+		return signBlock(block, engine.Keys[0])
+	}
+	if len(block.Transactions) < 2 || block.Transactions[1].FlagCoinbaseTx() {
+		return ruleError(ErrBadStake, "block %d has no coinstake", block.Height)
+	}
+
+	owner := block.Transactions[1].Inputs[0].PubKey
+	for _, key := range engine.Keys {
+		if bytes.Equal(publicKeyBytes(key), owner) {
+			return signBlock(block, key)
+		}
+	}
+	return ruleError(ErrBadSeal, "block %d must be signed by %s, whose key is not available",
+		block.Height, wallet.PubKeyHashToAddress(wallet.PublicKeyHash(owner)))
+}
+
+// VerifyHeader checks the block signature. Whether the signer owns the
+// stake is checked along with the transactions.
+func (engine StakeEngine) VerifyHeader(block *Block) error {
+	return verifyBlockSignature(block)
+}
+
+func (engine StakeEngine) Weight(block *Block) *big.Int {
+	return block.Work()
+}
+
+// kernelHash is what a staked output is drawn with for the block after
+// previousHash stamped creationTime. Only the 16-second slot of the time
+// counts, so restamping a block within the slot does not buy more draws.
+func kernelHash(previousHash []byte, creationTime int64, stake TxInput) []byte {
+	data := bytes.Join([][]byte{
+		previousHash,
+		stake.ID,
+		ConvertIntToHex(int64(stake.Out)),
+		ConvertIntToHex(creationTime &^ stakeTimeMask),
+	}, []byte{})
+	hash := sha256.Sum256(data)
+	return hash[:]
+}
+
+func kernelMeetsTarget(kernel []byte, bits uint32, value int) bool {
+	target := new(big.Int).Mul(CompactToBig(bits), big.NewInt(int64(value)))
+	return new(big.Int).SetBytes(kernel).Cmp(target) < 0
+}
+
+// checkStake checks that the block's coinstake spends one output back to
+// its owner in full, that the output wins the draw for the block's parent
+// and time, and that the block is signed by the owner of the stake. The
+// coinstake signature and the maturity of the stake were checked with the
+// other transactions.
+func (engine StakeEngine) checkStake(block *Block, lookup outputLookup) error {
+	if block.Height == 0 {
+		return nil
+	}
+	if len(block.Transactions) < 2 || block.Transactions[1].FlagCoinbaseTx() {
+		return ruleError(ErrBadStake, "block %x has no coinstake", block.Hash)
+	}
+	coinstake := block.Transactions[1]
+	if len(coinstake.Inputs) != 1 {
+		return ruleError(ErrBadStake, "block %x has a coinstake spending %d outputs, expected one", block.Hash, len(coinstake.Inputs))
+	}
+	stake := coinstake.Inputs[0]
+	if !bytes.Equal(block.PubKey, stake.PubKey) {
+		return ruleError(ErrBadSeal, "block %x is not signed by the owner of its stake", block.Hash)
+	}
+	out, err := lookup(stake.ID, stake.Out)
+	if err != nil {
+		return ruleError(ErrMissingOutput, "block %x stakes %x:%d: %v", block.Hash, stake.ID, stake.Out, err)
+	}
+	for _, output := range coinstake.Outputs {
+		if !bytes.Equal(output.PubKeyHash, out.PubKeyHash) {
+			return ruleError(ErrBadStake, "block %x has a coinstake paying %x, not the owner of its stake",
+				block.Hash, output.PubKeyHash)
+		}
+	}
+	if paid, err := coinstake.outputValue(); err != nil || paid != out.Value {
+		return ruleError(ErrBadStake, "block %x has a coinstake paying back %d of the %d coins staked", block.Hash, paid, out.Value)
+	}
+	if !kernelMeetsTarget(kernelHash(block.PreviousHash, block.CreationTime, stake), block.Bits, out.Value) {
+		return ruleError(ErrBadStake, "block %x stakes %d coins from %x:%d, which is not enough for its target",
+			block.Hash, out.Value, stake.ID, stake.Out)
+	}
+	return nil
+}
+
+// Stake tries to seal a block of transactions on top of the tip with one of
+// w's mature outputs, which the block's coinstake pays back to w in full.
+// The coinbase pays the subsidy and the fees to w as well. It returns
+// ErrNoKernel if no output wins the draw at the current time; the draw
+// changes every second and with every new block. Outputs spent by
+// transactions cannot be staked. The chain's engine must hold w's key.
+func (chain *BlockChain) Stake(ctx context.Context, w *wallet.Wallet, transactions []*Transaction) (*Block, error) {
+	tip, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		return nil, err
+	}
+	rules, err := nextHeaderRules(chain.engine(), chain.params(), chain.Database, tip, chain.now())
+	if err != nil {
+		return nil, err
+	}
+	creationTime := nextBlockTime(rules)
+	height := tip.Height + 1
+
+	spent := make(map[string]bool)
+	for _, tx := range transactions {
+		for _, in := range tx.Inputs {
+			spent[string(utxoKey(in.ID, in.Out))] = true
+		}
+	}
+
+	var coinstake *Transaction
+	staked := 0
+	address := string(w.Address())
+	pubKeyHash := wallet.PublicKeyHash(w.PublicKey)
+	err = chain.Database.Iterate(utxoPrefix, false, func(key, value []byte) bool {
+		out := DeserializeOutput(value)
+		if !out.IsLockedWithKey(pubKeyHash) || spent[string(key)] {
+			return true
+		}
+		txID, outID := splitUtxoKey(key)
+		coinbaseHeight, err := readCoinbaseHeight(chain.Database.Get, txID)
+		if err != nil {
+			log.Panic(err)
+		}
+		if !isMature(coinbaseHeight, height, chain.params().CoinbaseMaturity) {
+			return true
+		}
+		staked++
+		stake := TxInput{txID, outID, nil, w.PublicKey}
+		if !kernelMeetsTarget(kernelHash(tip.Hash, creationTime, stake), rules.bits, out.Value) {
+			return true
+		}
+		coinstake = &Transaction{nil, []TxInput{stake}, []TxOutput{*NewTXOutput(out.Value, address)}}
+		return false
+	})
+	if err != nil {
+		return nil, err
+	}
+	if staked == 0 {
+		return nil, ErrNothingToStake
+	}
+	if coinstake == nil {
+		return nil, ErrNoKernel
+	}
+
+	chain.SignTransaction(coinstake, w.PrivateKey)
+	coinstake.ID = coinstake.Hash()
+	return chain.mineBlock(ctx, address, append([]*Transaction{coinstake}, transactions...), creationTime)
+}
@@ -0,0 +1,94 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gustavoddoki/GoBlockchain/wallet"
+)
+
+// newStakeChain creates a proof of stake chain in memory whose genesis
+// block pays the returned wallet, which is also the chain's staking key.
+func newStakeChain(t *testing.T) (*BlockChain, *wallet.Wallet) {
+	t.Helper()
+	params := RegtestParams
+	params.Consensus = stakeEngine
+	staker := wallet.CreateNewWallet()
+	engine := StakeEngine{[]ecdsa.PrivateKey{staker.PrivateKey}}
+	chain := CreateBlockchainWithStore(NewMemoryStore(), string(staker.Address()), engine, &params)
+	return chain, staker
+}
+
+// drawTime returns the first time after parent, in steps of a slot, at
+// which output out of prev wins the draw for the next block or, if win is
+// false, loses it.
+func drawTime(t *testing.T, chain *BlockChain, parent *Block, prev *Transaction, out int, win bool) int64 {
+	t.Helper()
+	rules, err := nextHeaderRules(chain.engine(), chain.params(), chain.Database, parent, time.Unix(parent.CreationTime, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	stake := TxInput{prev.ID, out, nil, nil}
+	for creationTime := parent.CreationTime + 1; creationTime < parent.CreationTime+1<<20; creationTime += stakeTimeMask + 1 {
+		if kernelMeetsTarget(kernelHash(parent.Hash, creationTime, stake), rules.bits, prev.Outputs[out].Value) == win {
+			return creationTime
+		}
+	}
+	t.Fatalf("no draw with outcome %v", win)
+	return 0
+}
+
+func TestKernelIgnoresTimeWithinSlot(t *testing.T) {
+	stake := TxInput{[]byte("stake"), 0, nil, nil}
+	slot := int64(1700000000) &^ stakeTimeMask
+	first := kernelHash([]byte("parent"), slot, stake)
+	if last := kernelHash([]byte("parent"), slot+stakeTimeMask, stake); !bytes.Equal(first, last) {
+		t.Errorf("kernel changes within a slot, from %x to %x", first, last)
+	}
+	if next := kernelHash([]byte("parent"), slot+stakeTimeMask+1, stake); bytes.Equal(first, next) {
+		t.Error("kernel is the same in the next slot")
+	}
+}
+
+func TestCheckStakeRejects(t *testing.T) {
+	chain, staker := newStakeChain(t)
+	genesis := mustBlock(t, chain, chain.LastHash)
+	staked := genesis.Transactions[0]
+	value := staked.Outputs[0].Value
+	address := string(staker.Address())
+	win := drawTime(t, chain, genesis, staked, 0, true)
+	lose := drawTime(t, chain, genesis, staked, 0, false)
+
+	tests := []struct {
+		name         string
+		creationTime int64
+		now          int64
+		outputs      []TxOutput
+		err          error
+	}{
+		{"losing draw", lose, lose, []TxOutput{*NewTXOutput(value, address)}, ErrBadStake},
+		{"paid to someone else", win, win, []TxOutput{*NewTXOutput(value, testAddress())}, ErrBadStake},
+		{"partly paid to someone else", win, win,
+			[]TxOutput{*NewTXOutput(value-1, address), *NewTXOutput(1, testAddress())}, ErrBadStake},
+		{"not paid back in full", win, win, []TxOutput{*NewTXOutput(value-1, address)}, ErrBadStake},
+		{"stamped too far ahead", win, win - maxFutureStakeTime - 1, []TxOutput{*NewTXOutput(value, address)}, ErrBadTimestamp},
+		// Last, since the block it accepts leaves the others on a side branch.
+		{"winning draw", win, win, []TxOutput{*NewTXOutput(value, address)}, nil},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			now := time.Unix(test.now, 0)
+			chain.Clock = func() time.Time { return now }
+			coinstake := spendTo(staked, 0, staker, staker, test.outputs...)
+			coinbase := CreateCoinbaseTx(address, "", chain.params().subsidy(1))
+			block := sealTransactions(t, chain, genesis, test.creationTime, coinbase, coinstake)
+			if err := chain.AcceptBlock(block); !errors.Is(err, test.err) {
+				t.Errorf("accepting the block returned %v, want %v", err, test.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package blockchain
+
+import "errors"
+
+var ErrNotFound = errors.New("Key not found")
+
+// The keyspace is split into namespaces by a one-letter prefix: "b/" for
+// blocks, "w/" for what is known about each block, "c/" for the blocks no
+// other block builds on, "r/" for the undo data of connected blocks, "m/"
+// for chain metadata, "i/" for index bookkeeping and one prefix per index.
+// Each derived index remembers the last block applied to it, written in the
+// same batch as the index entries themselves, so startup can tell when it
+// fell behind the tip.
+var (
+	blockPrefix     = []byte("b/")
+	heightPrefix    = []byte("h/")
+	utxoPrefix      = []byte("u/")
+	txIndexPrefix   = []byte("t/")
+	addrIndexPrefix = []byte("a/")
+	nodePrefix      = []byte("w/")
+	chainTipPrefix  = []byte("c/")
+	undoPrefix      = []byte("r/")
+	coinbasePrefix  = []byte("k/")
+
+	tipKey            = []byte("m/tip")
+	schemaKey         = []byte("m/schema")
+	txIndexFlagKey    = []byte("m/txindex")
+	pruneDepthKey     = []byte("m/prune")
+	rewardScheduleKey = []byte("m/rewards")
+	paramsKey         = []byte("m/params")
+	consensusKey      = []byte("m/consensus")
+	utxoTipKey        = []byte("i/utxo")
+	txIndexTipKey     = []byte("i/tx")
+)
+
+// ChainStore is the storage a BlockChain runs on. Blocks and the tip have
+// dedicated accessors; the indexes built on top of the chain use the plain
+// key/value methods under their own key prefixes.
+type ChainStore interface {
+	GetBlock(hash []byte) (*Block, error)
+	GetTip() ([]byte, error)
+	Get(key []byte) ([]byte, error)
+	// Iterate calls fn for every key starting with prefix, in key order (or
+	// reverse key order), until fn returns false.
+	Iterate(prefix []byte, reverse bool, fn func(key, value []byte) bool) error
+	// Update runs fn inside a batch that is committed atomically when fn
+	// returns nil and discarded otherwise.
+	Update(fn func(batch Batch) error) error
+	Close() error
+}
+
+// Batch is a pending set of writes. Reads through a batch observe its own
+// writes.
+type Batch interface {
+	Get(key []byte) ([]byte, error)
+	Put(key []byte, value []byte) error
+	Delete(key []byte) error
+	PutBlock(block *Block) error
+	GetTip() ([]byte, error)
+	SetTip(hash []byte) error
+}
+
+func blockKey(hash []byte) []byte {
+	return append(append([]byte{}, blockPrefix...), hash...)
+}
+
+// readBlock reads a block through a plain key lookup, which lets a batch
+// see blocks written earlier in the same batch.
+func readBlock(get func(key []byte) ([]byte, error), hash []byte) (*Block, error) {
+	data, err := get(blockKey(hash))
+	if err != nil {
+		return nil, err
+	}
+	return DeserializeBlock(data)
+}
+
+func deleteByPrefix(store ChainStore, prefix []byte) error {
+	var keys [][]byte
+	err := store.Iterate(prefix, false, func(key, value []byte) bool {
+		keys = append(keys, key)
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	collectSize := 100000
+	for start := 0; start < len(keys); start += collectSize {
+		end := start + collectSize
+		if end > len(keys) {
+			end = len(keys)
+		}
+		err := store.Update(func(batch Batch) error {
+			for _, key := range keys[start:end] {
+				if err := batch.Delete(key); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
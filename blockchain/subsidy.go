@@ -0,0 +1,116 @@
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+)
+
+// RewardSchedule sets how many new coins each block may mint. The subsidy
+// starts at InitialSubsidy, halves every HalvingInterval blocks and stops
+// once MaxSupply coins have been minted in all.
+type RewardSchedule struct {
+	InitialSubsidy int `json:"initialSubsidy"`
+	// HalvingInterval of zero means the subsidy never halves.
+	HalvingInterval int `json:"halvingInterval"`
+	MaxSupply       int `json:"maxSupply"`
+}
+
+var DefaultRewardSchedule = RewardSchedule{100, 105000, 21000000}
+
+func (schedule RewardSchedule) Validate() error {
+	if schedule.InitialSubsidy <= 0 || schedule.MaxSupply <= 0 {
+		return fmt.Errorf("Initial subsidy and maximum supply must be positive, got %d and %d",
+			schedule.InitialSubsidy, schedule.MaxSupply)
+	}
+	if schedule.MaxSupply > MaxMoney {
+		return fmt.Errorf("Maximum supply must be at most %d, got %d", MaxMoney, schedule.MaxSupply)
+	}
+	if schedule.HalvingInterval < 0 {
+		return fmt.Errorf("Halving interval must not be negative, got %d", schedule.HalvingInterval)
+	}
+	return nil
+}
+
+// Subsidy returns the number of coins the coinbase of the block at height
+// may mint. Blocks minting the last coins before the supply cap get only
+// what is left.
+func (schedule RewardSchedule) Subsidy(height int) int {
+	minted := 0
+	for era := 0; ; era++ {
+		subsidy := 0
+		if era < 63 {
+			subsidy = schedule.InitialSubsidy >> uint(era)
+		}
+		if subsidy == 0 {
+			return 0
+		}
+		start := era * schedule.HalvingInterval
+		if schedule.HalvingInterval == 0 || height < start+schedule.HalvingInterval {
+			minted += subsidy * (height - start)
+			if minted >= schedule.MaxSupply {
+				return 0
+			}
+			if left := schedule.MaxSupply - minted; subsidy > left {
+				return left
+			}
+			return subsidy
+		}
+		minted += subsidy * schedule.HalvingInterval
+	}
+}
+
+func (schedule RewardSchedule) Serialize() []byte {
+	var buffer bytes.Buffer
+	err := gob.NewEncoder(&buffer).Encode(schedule)
+	if err != nil {
+		log.Panic(err)
+	}
+	return buffer.Bytes()
+}
+
+// readRewardSchedule returns the schedule recorded by chains created before
+// their parameters were. Chains created before schedules were recorded use
+// the default one.
+func readRewardSchedule(get func(key []byte) ([]byte, error)) (RewardSchedule, error) {
+	var schedule RewardSchedule
+	value, err := get(rewardScheduleKey)
+	if errors.Is(err, ErrNotFound) {
+		return DefaultRewardSchedule, nil
+	}
+	if err != nil {
+		return schedule, err
+	}
+	err = gob.NewDecoder(bytes.NewReader(value)).Decode(&schedule)
+	return schedule, err
+}
+
+// MineBlock mines a block of transactions on top of the tip, led by a
+// coinbase paying the block subsidy and the fees of the transactions to
+// address.
+func (chain *BlockChain) MineBlock(ctx context.Context, address string, transactions []*Transaction) (*Block, error) {
+	return chain.mineBlock(ctx, address, transactions, 0)
+}
+
+func (chain *BlockChain) mineBlock(ctx context.Context, address string, transactions []*Transaction, creationTime int64) (*Block, error) {
+	height := chain.GetBestHeight() + 1
+	reward := chain.params().subsidy(height)
+	inBlock := make(map[string]Transaction)
+	for _, tx := range transactions {
+		fee, err := transactionFee(tx, height, inBlock, unspentOutputs(chain.Database.Get))
+		if err != nil {
+			return nil, err
+		}
+		var ok bool
+		if reward, ok = addValue(reward, fee); !ok {
+			return nil, ruleError(ErrBadTransaction, "transactions pay more than %d coins in fees", MaxMoney)
+		}
+		inBlock[hex.EncodeToString(tx.ID)] = *tx
+	}
+	coinbase := CreateCoinbaseTx(address, "", reward)
+	return chain.addBlock(ctx, append([]*Transaction{coinbase}, transactions...), creationTime)
+}
@@ -0,0 +1,77 @@
+package blockchain
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestSubsidy(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule RewardSchedule
+		heights  []int
+		want     []int
+	}{
+		{"halving", RewardSchedule{100, 10, 1000000}, []int{0, 9, 10, 19, 20, 29, 30}, []int{100, 100, 50, 50, 25, 25, 12}},
+		{"supply cap", RewardSchedule{100, 10, 1480}, []int{9, 10, 18, 19, 20, 100}, []int{100, 50, 50, 30, 0, 0}},
+		{"no halving", RewardSchedule{100, 0, 250}, []int{0, 1, 2, 3, 1000}, []int{100, 100, 50, 0, 0}},
+		{"halved to nothing", RewardSchedule{3, 1, 1000}, []int{0, 1, 2, 3}, []int{3, 1, 0, 0}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for i, height := range test.heights {
+				if got := test.schedule.Subsidy(height); got != test.want[i] {
+					t.Errorf("subsidy at height %d is %d, want %d", height, got, test.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSubsidyNeverExceedsMaxSupply(t *testing.T) {
+	for _, schedule := range []RewardSchedule{{100, 10, 1480}, {100, 0, 250}, {7, 3, 1000000}} {
+		minted := 0
+		for height := 0; height < 10000; height++ {
+			minted += schedule.Subsidy(height)
+		}
+		if minted > schedule.MaxSupply {
+			t.Errorf("%+v minted %d", schedule, minted)
+		}
+	}
+}
+
+func TestCoinbaseCannotOverflow(t *testing.T) {
+	chain, owner := newTestChain(t, &RegtestParams)
+	genesis := mustBlock(t, chain, chain.LastHash)
+	address := string(owner.Address())
+	subsidy := chain.params().subsidy(1)
+
+	coinbase := func(values ...int) *Transaction {
+		tx := CreateCoinbaseTx(address, "", values[0])
+		for _, value := range values[1:] {
+			tx.Outputs = append(tx.Outputs, *NewTXOutput(value, address))
+		}
+		tx.SetID()
+		return tx
+	}
+	tests := []struct {
+		name     string
+		coinbase *Transaction
+		want     error
+	}{
+		{"subsidy", coinbase(subsidy), nil},
+		{"subsidy split", coinbase(subsidy-1, 1), nil},
+		{"above the subsidy", coinbase(subsidy, 1), ErrCoinbaseTooLarge},
+		{"wrapping around", coinbase(math.MaxInt, math.MaxInt, 3), ErrBadTransaction},
+		{"above MaxMoney", coinbase(MaxMoney+1, -MaxMoney), ErrBadTransaction},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := chain.ValidateBlock(sealTransactions(t, chain, genesis, 0, test.coinbase))
+			if !errors.Is(err, test.want) {
+				t.Errorf("got %v, want %v", err, test.want)
+			}
+		})
+	}
+}
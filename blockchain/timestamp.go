@@ -0,0 +1,109 @@
+package blockchain
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+const (
+	// medianTimeSpan is the number of blocks whose median time a new block
+	// must be later than.
+	medianTimeSpan = 11
+	// maxFutureBlockTime is how far ahead of the local clock a block may be
+	// stamped, in seconds.
+	maxFutureBlockTime = 2 * 60 * 60
+)
+
+// headerRules holds what the chain requires of the header of the block that
+// follows a given parent.
+type headerRules struct {
+	bits       uint32
+	medianTime int64
+	now        time.Time
+	// maxFuture is how far ahead of now the block may be stamped, in
+	// seconds.
+	maxFuture int64
+	// checkpoint is the hash the block must have, if any.
+	checkpoint []byte
+	// legacy is set below the legacy height of the chain.
+	legacy bool
+}
+
+func nextHeaderRules(engine ConsensusEngine, params *ChainParams, store ChainStore, parent *Block, now time.Time) (headerRules, error) {
+	bits, err := engine.NextBits(store, params, parent)
+	if err != nil {
+		return headerRules{}, err
+	}
+	medianTime, err := medianTimePast(store, parent)
+	if err != nil {
+		return headerRules{}, err
+	}
+	height := 0
+	if parent != nil {
+		height = parent.Height + 1
+	}
+	// A staker draws again in every slot it may stamp its block with, so
+	// proof of stake blocks get less room.
+	maxFuture := int64(maxFutureBlockTime)
+	if _, ok := engine.(stakeChecker); ok {
+		maxFuture = maxFutureStakeTime
+	}
+	return headerRules{bits, medianTime, now, maxFuture, params.checkpoint(height), params.legacy(height)}, nil
+}
+
+// medianTimePast returns the median creation time of parent and the blocks
+// before it, up to medianTimeSpan blocks in all. It is zero for a genesis
+// block.
+func medianTimePast(store ChainStore, parent *Block) (int64, error) {
+	var times []int64
+	block := parent
+	for block != nil && len(times) < medianTimeSpan {
+		times = append(times, block.CreationTime)
+		if len(block.PreviousHash) == 0 {
+			break
+		}
+		previous, err := store.GetBlock(block.PreviousHash)
+		if err != nil {
+			return 0, fmt.Errorf("looking up block %d for the median time: %w", block.Height-1, err)
+		}
+		block = previous
+	}
+	if len(times) == 0 {
+		return 0, nil
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	return times[len(times)/2], nil
+}
+
+// nextBlockTime is the time a block mined now is stamped with: the current
+// time, or just after the median time if the clock is behind it.
+func nextBlockTime(rules headerRules) int64 {
+	if rules.now.Unix() <= rules.medianTime {
+		return rules.medianTime + 1
+	}
+	return rules.now.Unix()
+}
+
+func checkBlockTime(block *Block, rules headerRules) error {
+	if rules.legacy {
+		return nil
+	}
+	if block.CreationTime <= rules.medianTime {
+		return ruleError(ErrBadTimestamp, "block %x is stamped %s, not after the median time of the previous blocks, %s",
+			block.Hash, time.Unix(block.CreationTime, 0).UTC(), time.Unix(rules.medianTime, 0).UTC())
+	}
+	if block.CreationTime > rules.now.Unix()+rules.maxFuture {
+		return ruleError(ErrBadTimestamp, "block %x is stamped %s, more than %s in the future",
+			block.Hash, time.Unix(block.CreationTime, 0).UTC(), time.Duration(rules.maxFuture)*time.Second)
+	}
+	return nil
+}
+
+// now reads the chain's clock, which defaults to the system time.
+func (chain *BlockChain) now() time.Time {
+	if chain.Clock != nil {
+		return chain.Clock()
+	}
+	return time.Now()
+}
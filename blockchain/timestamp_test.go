@@ -0,0 +1,83 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCheckBlockTime(t *testing.T) {
+	params := RegtestParams
+	params.GenesisTime = 1700000000
+	chain, owner := newTestChain(t, &params)
+
+	// Blocks 1 to 5 a minute apart, checked by a clock that follows them.
+	clock := time.Unix(params.GenesisTime, 0)
+	chain.Clock = func() time.Time { return clock }
+	for i := 1; i <= 5; i++ {
+		clock = clock.Add(time.Minute)
+		block := sealBlockAt(t, chain, mustBlock(t, chain, chain.LastHash), clock.Unix(), string(owner.Address()))
+		if err := chain.AcceptBlock(block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The median of the six blocks is that of block 3.
+	median := params.GenesisTime + 3*60
+	now := time.Unix(params.GenesisTime+60*60, 0)
+	clock = now
+	tests := []struct {
+		name         string
+		creationTime int64
+		err          error
+	}{
+		{"at the median", median, ErrBadTimestamp},
+		{"after the median", median + 1, nil},
+		{"two hours ahead", now.Unix() + maxFutureBlockTime, nil},
+		{"more than two hours ahead", now.Unix() + maxFutureBlockTime + 1, ErrBadTimestamp},
+	}
+	tip := mustBlock(t, chain, chain.LastHash)
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			block := sealBlockAt(t, chain, tip, test.creationTime, string(owner.Address()))
+			if err := chain.ValidateBlock(block); !errors.Is(err, test.err) {
+				t.Errorf("got %v, want %v", err, test.err)
+			}
+		})
+	}
+}
+
+func TestLegacyExemptionFollowsHeight(t *testing.T) {
+	chain := LoadBlockChain(loadFixture(t, "schema0-three.txt"))
+	tip := mustBlock(t, chain, chain.LastHash)
+	genesis, err := chain.GetBlockByHeight(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seal := func(version int, bits uint32, creationTime int64) *Block {
+		return sealHeader(t, chain, tip, version, bits, creationTime)
+	}
+	rules, err := nextHeaderRules(chain.engine(), chain.params(), chain.Database, tip, chain.now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	bits, now := rules.bits, rules.now.Unix()
+
+	tests := []struct {
+		name  string
+		block *Block
+		err   error
+	}{
+		{"claiming to be legacy", seal(0, 0, genesis.CreationTime-1), ErrBadVersion},
+		{"claiming an older version", seal(currentBlockVersion-1, bits, now), ErrBadVersion},
+		{"stamped before genesis", seal(currentBlockVersion, bits, genesis.CreationTime-1), ErrBadTimestamp},
+		{"current", seal(currentBlockVersion, bits, now), nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if err := chain.ValidateBlock(test.block); !errors.Is(err, test.err) {
+				t.Errorf("got %v, want %v", err, test.err)
+			}
+		})
+	}
+}
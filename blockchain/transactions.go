@@ -25,14 +25,47 @@ type Transaction struct {
 func (tx *Transaction) Hash() []byte {
 	var hash [32]byte
 
-	txCopy := *tx
-	txCopy.ID = []byte{}
-
-	hash = sha256.Sum256(txCopy.Serialize())
+	hash = sha256.Sum256(tx.hashData())
 
 	return hash[:]
 }
 
+// hashData encodes every field but the ID in a fixed layout. Gob output is
+// not used here because it depends on which types the process has already
+// encoded, so the same transaction could hash differently on another node.
+func (tx *Transaction) hashData() []byte {
+	var data bytes.Buffer
+
+	writeBytes := func(field []byte) {
+		data.Write(ConvertIntToHex(int64(len(field))))
+		data.Write(field)
+	}
+
+	data.Write(ConvertIntToHex(int64(len(tx.Inputs))))
+	for _, in := range tx.Inputs {
+		writeBytes(in.ID)
+		data.Write(ConvertIntToHex(int64(in.Out)))
+		writeBytes(in.Signature)
+		writeBytes(in.PubKey)
+	}
+
+	data.Write(ConvertIntToHex(int64(len(tx.Outputs))))
+	for _, out := range tx.Outputs {
+		data.Write(ConvertIntToHex(int64(out.Value)))
+		writeBytes(out.PubKeyHash)
+	}
+
+	return data.Bytes()
+}
+
+// hashFor hashes tx the way blocks of version do.
+func (tx *Transaction) hashFor(version int) []byte {
+	if version < merkleBlockVersion {
+		return tx.legacyHash()
+	}
+	return tx.Hash()
+}
+
 func (tx Transaction) Serialize() []byte {
 	var encoded bytes.Buffer
 
@@ -46,26 +79,23 @@ func (tx Transaction) Serialize() []byte {
 }
 
 func (tx *Transaction) SetID() {
-	var encoded bytes.Buffer
-	var hash [32]byte
-
-	encode := gob.NewEncoder(&encoded)
-	err := encode.Encode(tx)
-	if err != nil {
-		log.Panic()
-	}
-
-	hash = sha256.Sum256(encoded.Bytes())
-	tx.ID = hash[:]
+	tx.ID = tx.Hash()
 }
 
-func CreateCoinbaseTx(to, data string) *Transaction {
+// CreateCoinbaseTx pays value newly minted coins to to. Without data the
+// coinbase gets random data, so that two coinbases paying the same address
+// do not end up with the same ID.
+func CreateCoinbaseTx(to, data string, value int) *Transaction {
 	if data == "" {
-		data = fmt.Sprintf("Reward to %s", to)
+		random := make([]byte, 20)
+		if _, err := rand.Read(random); err != nil {
+			log.Panic(err)
+		}
+		data = fmt.Sprintf("Reward to %s, %x", to, random)
 	}
 
 	txin := TxInput{[]byte{}, -1, nil, []byte(data)}
-	txout := NewTXOutput(100, to)
+	txout := NewTXOutput(value, to)
 
 	tx := Transaction{nil, []TxInput{txin}, []TxOutput{*txout}}
 	tx.SetID()
@@ -77,19 +107,44 @@ func (tx *Transaction) FlagCoinbaseTx() bool {
 	return len(tx.Inputs) == 1 && len(tx.Inputs[0].ID) == 0 && tx.Inputs[0].Out == -1
 }
 
-func CreateTransaction(from string, to string, amount int, chain *BlockChain) *Transaction {
+// MaxMoney bounds every amount of coins: each output, and what the
+// outputs of a transaction or a block add up to. Two amounts within it add
+// up without overflowing.
+const MaxMoney = 1 << 53
+
+// addValue adds value to total, which is within MaxMoney, and reports
+// whether the sum is within MaxMoney too.
+func addValue(total, value int) (int, bool) {
+	if value < 0 || value > MaxMoney-total {
+		return 0, false
+	}
+	return total + value, true
+}
+
+// outputValue adds up what the outputs of tx pay. Negative outputs, or
+// outputs adding up to more than MaxMoney, break the rules.
+func (tx *Transaction) outputValue() (int, error) {
+	total := 0
+	for i, out := range tx.Outputs {
+		var ok bool
+		if total, ok = addValue(total, out.Value); !ok {
+			return 0, ruleError(ErrBadTransaction, "transaction %x output %d of %d takes its outputs out of range", tx.ID, i, out.Value)
+		}
+	}
+	return total, nil
+}
+
+// CreateTransaction sends amount to to and leaves fee for the miner; what
+// is left of the spent outputs comes back to the wallet as change.
+func CreateTransaction(w *wallet.Wallet, to string, amount int, fee int, UTXO *UTXOSet) *Transaction {
 	var inputs []TxInput
 	var outputs []TxOutput
 
-	wallets, err := wallet.CreateWallets()
-	if err != nil {
-		log.Panic(err)
-	}
-	w := wallets.GetWallet(from)
+	from := string(w.Address())
 	pubKeyHash := wallet.PublicKeyHash(w.PublicKey)
-	acc, valid_outputs := chain.FindSpendableOutputs(pubKeyHash, amount)
+	acc, valid_outputs := UTXO.FindSpendableOutputs(pubKeyHash, amount+fee)
 
-	if acc < amount {
+	if acc < amount+fee {
 		log.Panic("Error: not enough funds.")
 	}
 
@@ -106,12 +161,12 @@ func CreateTransaction(from string, to string, amount int, chain *BlockChain) *T
 
 	outputs = append(outputs, *NewTXOutput(amount, to))
 
-	if acc > amount {
-		outputs = append(outputs, *NewTXOutput(acc-amount, from))
+	if acc > amount+fee {
+		outputs = append(outputs, *NewTXOutput(acc-amount-fee, from))
 	}
 	transaction := Transaction{nil, inputs, outputs}
+	UTXO.Blockchain.SignTransaction(&transaction, w.PrivateKey)
 	transaction.ID = transaction.Hash()
-	chain.SignTransaction(&transaction, w.PrivateKey)
 
 	return &transaction
 }
@@ -140,7 +195,9 @@ func (tx *Transaction) Sign(privKey ecdsa.PrivateKey, prevTXs map[string]Transac
 		if err != nil {
 			log.Panic(err)
 		}
-		signature := append(r.Bytes(), s.Bytes()...)
+		signature := make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
 
 		tx.Inputs[inId].Signature = signature
 	}
@@ -163,46 +220,94 @@ func (tx *Transaction) TrimmedCopy() Transaction {
 }
 
 func (tx *Transaction) Verify(prevTXs map[string]Transaction) bool {
+	return tx.verify(prevTXs, currentBlockVersion)
+}
+
+// verify checks the signatures of tx as made for a block of version.
+func (tx *Transaction) verify(prevTXs map[string]Transaction, version int) bool {
 	if tx.FlagCoinbaseTx() {
 		return true
 	}
 
 	for _, in := range tx.Inputs {
-		if prevTXs[hex.EncodeToString(in.ID)].ID == nil {
-			log.Panic("Previous transaction does not exist.")
+		prevTx := prevTXs[hex.EncodeToString(in.ID)]
+		if prevTx.ID == nil || in.Out < 0 || in.Out >= len(prevTx.Outputs) {
+			return false
+		}
+		if !bytes.Equal(wallet.PublicKeyHash(in.PubKey), prevTx.Outputs[in.Out].PubKeyHash) {
+			return false
 		}
 	}
 
 	txCopy := tx.TrimmedCopy()
-	curve := elliptic.P256()
 
 	for inId, in := range tx.Inputs {
 		prevTx := prevTXs[hex.EncodeToString(in.ID)]
 		txCopy.Inputs[inId].Signature = nil
 		txCopy.Inputs[inId].PubKey = prevTx.Outputs[in.Out].PubKeyHash
-		txCopy.ID = txCopy.Hash()
+		txCopy.ID = txCopy.hashFor(version)
 		txCopy.Inputs[inId].PubKey = nil
 
-		r := big.Int{}
-		s := big.Int{}
-		signature_len := len(in.Signature)
-		r.SetBytes(in.Signature[:(signature_len / 2)])
-		s.SetBytes(in.Signature[(signature_len / 2):])
-
-		x := big.Int{}
-		y := big.Int{}
-		key_len := len(in.PubKey)
-		x.SetBytes(in.Signature[:(key_len / 2)])
-		y.SetBytes(in.Signature[(key_len / 2):])
-
-		rawPubKey := ecdsa.PublicKey{Curve: curve, X: &x, Y: &y}
-		if !ecdsa.Verify(&rawPubKey, txCopy.ID, &r, &s) {
+		verified := false
+		for _, pubKey := range publicKeys(in.PubKey, version) {
+			if verifySignature(&pubKey, txCopy.ID, in.Signature, version) {
+				verified = true
+				break
+			}
+		}
+		if !verified {
 			return false
 		}
 	}
 	return true
 }
 
+// publicKeys returns the points an X and Y pair stored one after the other
+// may stand for. Like signatures, the public keys of the first release were
+// not padded, so in blocks older than merkleBlockVersion a shorter key may
+// split anywhere; only splits that give a point on the curve are kept.
+func publicKeys(pubKey []byte, version int) []ecdsa.PublicKey {
+	curve := elliptic.P256()
+	first, last := len(pubKey)/2, len(pubKey)/2
+	if version < merkleBlockVersion {
+		first, last = len(pubKey)-32, 32
+		if first < 1 {
+			first = 1
+		}
+	}
+	var keys []ecdsa.PublicKey
+	for split := first; split <= last && split < len(pubKey); split++ {
+		x := new(big.Int).SetBytes(pubKey[:split])
+		y := new(big.Int).SetBytes(pubKey[split:])
+		if version < merkleBlockVersion && !curve.IsOnCurve(x, y) {
+			continue
+		}
+		keys = append(keys, ecdsa.PublicKey{Curve: curve, X: x, Y: y})
+	}
+	return keys
+}
+
+// verifySignature checks an r and s pair stored one after the other. The
+// first release did not pad them to 32 bytes each, so in blocks older than
+// merkleBlockVersion a shorter signature may split anywhere.
+func verifySignature(pubKey *ecdsa.PublicKey, hash []byte, signature []byte, version int) bool {
+	first, last := len(signature)/2, len(signature)/2
+	if version < merkleBlockVersion {
+		first, last = len(signature)-32, 32
+		if first < 1 {
+			first = 1
+		}
+	}
+	for split := first; split <= last && split < len(signature); split++ {
+		r := new(big.Int).SetBytes(signature[:split])
+		s := new(big.Int).SetBytes(signature[split:])
+		if ecdsa.Verify(pubKey, hash, r, s) {
+			return true
+		}
+	}
+	return false
+}
+
 func (tx Transaction) String() string {
 	var lines []string
 
@@ -2,6 +2,8 @@ package blockchain
 
 import (
 	"bytes"
+	"encoding/gob"
+	"log"
 
 	"github.com/gustavoddoki/GoBlockchain/wallet"
 )
@@ -38,3 +40,43 @@ func NewTXOutput(value int, address string) *TxOutput {
 	txo.Lock([]byte(address))
 	return txo
 }
+
+func (out TxOutput) Serialize() []byte {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	err := encoder.Encode(out)
+	if err != nil {
+		log.Panic(err)
+	}
+	return buffer.Bytes()
+}
+
+func SerializeOutputs(outs []TxOutput) []byte {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	err := encoder.Encode(outs)
+	if err != nil {
+		log.Panic(err)
+	}
+	return buffer.Bytes()
+}
+
+func DeserializeOutputs(data []byte) []TxOutput {
+	var outs []TxOutput
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(&outs)
+	if err != nil {
+		log.Panic(err)
+	}
+	return outs
+}
+
+func DeserializeOutput(data []byte) TxOutput {
+	var out TxOutput
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(&out)
+	if err != nil {
+		log.Panic(err)
+	}
+	return out
+}
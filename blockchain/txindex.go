@@ -0,0 +1,122 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"log"
+)
+
+type TxLocation struct {
+	BlockHash []byte
+	Position  int
+}
+
+func (loc TxLocation) Serialize() []byte {
+	var buffer bytes.Buffer
+	encoder := gob.NewEncoder(&buffer)
+	err := encoder.Encode(loc)
+	if err != nil {
+		log.Panic(err)
+	}
+	return buffer.Bytes()
+}
+
+func DeserializeTxLocation(data []byte) TxLocation {
+	var loc TxLocation
+	decoder := gob.NewDecoder(bytes.NewReader(data))
+	err := decoder.Decode(&loc)
+	if err != nil {
+		log.Panic(err)
+	}
+	return loc
+}
+
+func txIndexKey(txID []byte) []byte {
+	return append(append([]byte{}, txIndexPrefix...), txID...)
+}
+
+func txIndexEnabled(get func(key []byte) ([]byte, error)) (bool, error) {
+	_, err := get(txIndexFlagKey)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// indexTransactions records where each transaction of a connected block
+// lives. It is a no-op until the index has been enabled for the chain.
+func indexTransactions(batch Batch, block *Block) error {
+	enabled, err := txIndexEnabled(batch.Get)
+	if err != nil || !enabled {
+		return err
+	}
+	for position, tx := range block.Transactions {
+		loc := TxLocation{block.Hash, position}
+		if err := batch.Put(txIndexKey(tx.ID), loc.Serialize()); err != nil {
+			return err
+		}
+	}
+	return batch.Put(txIndexTipKey, block.Hash)
+}
+
+// unindexTransactions forgets the transactions of a block that is being
+// disconnected.
+func unindexTransactions(batch Batch, block *Block) error {
+	enabled, err := txIndexEnabled(batch.Get)
+	if err != nil || !enabled {
+		return err
+	}
+	for _, tx := range block.Transactions {
+		if err := batch.Delete(txIndexKey(tx.ID)); err != nil {
+			return err
+		}
+	}
+	return batch.Put(txIndexTipKey, block.PreviousHash)
+}
+
+func (chain *BlockChain) HasTxIndex() bool {
+	enabled, err := txIndexEnabled(chain.Database.Get)
+	if err != nil {
+		log.Panic(err)
+	}
+	return enabled
+}
+
+// ReindexTransactions rebuilds the transaction index from the blocks and
+// enables it, so every block connected afterwards is indexed as well.
+func (chain *BlockChain) ReindexTransactions() int {
+	err := chain.Database.Update(func(batch Batch) error {
+		if err := batch.Delete(txIndexTipKey); err != nil {
+			return err
+		}
+		return batch.Put(txIndexFlagKey, []byte{1})
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	UTXOSet{chain}.DeleteByPrefix(txIndexPrefix)
+
+	count := 0
+	chain.replay(chain.mustBlocksSince(nil), func(batch Batch, block *Block) error {
+		count += len(block.Transactions)
+		return indexTransactions(batch, block)
+	})
+	return count
+}
+
+func (chain *BlockChain) lookupTxIndex(ID []byte) (*TxLocation, bool, error) {
+	enabled, err := txIndexEnabled(chain.Database.Get)
+	if err != nil || !enabled {
+		return nil, false, err
+	}
+	value, err := chain.Database.Get(txIndexKey(ID))
+	if errors.Is(err, ErrNotFound) {
+		return nil, true, nil
+	}
+	if err != nil {
+		return nil, true, err
+	}
+	loc := DeserializeTxLocation(value)
+	return &loc, true, nil
+}
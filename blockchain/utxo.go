@@ -0,0 +1,215 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+)
+
+type UTXOSet struct {
+	Blockchain *BlockChain
+}
+
+func utxoKey(txID []byte, out int) []byte {
+	index := make([]byte, 4)
+	binary.BigEndian.PutUint32(index, uint32(out))
+	return bytes.Join([][]byte{utxoPrefix, txID, index}, []byte{})
+}
+
+func undoKey(blockHash []byte) []byte {
+	return append(append([]byte{}, undoPrefix...), blockHash...)
+}
+
+func splitUtxoKey(key []byte) ([]byte, int) {
+	txID := key[len(utxoPrefix) : len(key)-4]
+	out := binary.BigEndian.Uint32(key[len(key)-4:])
+	return txID, int(out)
+}
+
+// FindSpendableOutputs picks outputs locked with pubKeyHash worth at least
+// amount, leaving out coinbase outputs that could not be spent in the next
+// block yet.
+func (u UTXOSet) FindSpendableOutputs(pubKeyHash []byte, amount int) (int, map[string][]int) {
+	unspent_outs := make(map[string][]int)
+	accumulated := 0
+	height := u.Blockchain.GetBestHeight() + 1
+
+	err := u.Blockchain.Database.Iterate(utxoPrefix, false, func(key, value []byte) bool {
+		out := DeserializeOutput(value)
+		if out.IsLockedWithKey(pubKeyHash) {
+			txID, out_id := splitUtxoKey(key)
+			coinbaseHeight, err := readCoinbaseHeight(u.Blockchain.Database.Get, txID)
+			if err != nil {
+				log.Panic(err)
+			}
+			if !isMature(coinbaseHeight, height, u.Blockchain.params().CoinbaseMaturity) {
+				return true
+			}
+			txKey := hex.EncodeToString(txID)
+			accumulated += out.Value
+			unspent_outs[txKey] = append(unspent_outs[txKey], out_id)
+		}
+		return accumulated < amount
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return accumulated, unspent_outs
+}
+
+// UnspentTransaction returns the transaction txID with only its unspent
+// outputs filled in, which is all Sign and Verify need from the
+// transactions being spent, and works even when its block was pruned.
+func (u UTXOSet) UnspentTransaction(txID []byte) (Transaction, error) {
+	tx := Transaction{ID: txID}
+	prefix := append(append([]byte{}, utxoPrefix...), txID...)
+
+	err := u.Blockchain.Database.Iterate(prefix, false, func(key, value []byte) bool {
+		_, out_id := splitUtxoKey(key)
+		for len(tx.Outputs) <= out_id {
+			tx.Outputs = append(tx.Outputs, TxOutput{})
+		}
+		tx.Outputs[out_id] = DeserializeOutput(value)
+		return true
+	})
+	if err != nil {
+		return Transaction{}, err
+	}
+	if len(tx.Outputs) == 0 {
+		return Transaction{}, fmt.Errorf("Transaction %x has no unspent outputs", txID)
+	}
+	return tx, nil
+}
+
+func (u UTXOSet) CountOutputs() int {
+	counter := 0
+
+	err := u.Blockchain.Database.Iterate(utxoPrefix, false, func(key, value []byte) bool {
+		counter++
+		return true
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	return counter
+}
+
+// Reindex rebuilds the UTXO set, and the address index that is derived
+// alongside it, by replaying every block from genesis.
+func (u UTXOSet) Reindex() {
+	chain := u.Blockchain
+	err := chain.Database.Update(func(batch Batch) error {
+		return batch.Delete(utxoTipKey)
+	})
+	if err != nil {
+		log.Panic(err)
+	}
+	u.DeleteByPrefix(utxoPrefix)
+	u.DeleteByPrefix(coinbasePrefix)
+	u.DeleteByPrefix(addrIndexPrefix)
+
+	chain.replay(chain.mustBlocksSince(nil), applyBlock)
+}
+
+// Update applies a connected block to the set inside the caller's batch, so
+// the set always moves together with the chain tip. The outputs the block
+// spends are kept as its undo data, in the order of its inputs.
+func (u UTXOSet) Update(batch Batch, block *Block) error {
+	var spent []TxOutput
+	for _, tx := range block.Transactions {
+		if !tx.FlagCoinbaseTx() {
+			for _, in := range tx.Inputs {
+				key := utxoKey(in.ID, in.Out)
+				value, err := batch.Get(key)
+				if err != nil {
+					if errors.Is(err, ErrNotFound) {
+						return ruleError(ErrMissingOutput, "input %x:%d spends a missing or spent output", in.ID, in.Out)
+					}
+					return err
+				}
+				spent = append(spent, DeserializeOutput(value))
+				if err := batch.Delete(key); err != nil {
+					return err
+				}
+			}
+		}
+		if tx.FlagCoinbaseTx() {
+			if err := putCoinbaseHeight(batch, tx.ID, block.Height); err != nil {
+				return err
+			}
+		}
+		for out_id, out := range tx.Outputs {
+			key := utxoKey(tx.ID, out_id)
+			if _, err := batch.Get(key); err == nil {
+				return ruleError(ErrDuplicateTx, "transaction %x would replace its unspent output %d", tx.ID, out_id)
+			} else if !errors.Is(err, ErrNotFound) {
+				return err
+			}
+			if err := batch.Put(key, out.Serialize()); err != nil {
+				return err
+			}
+		}
+	}
+	return batch.Put(undoKey(block.Hash), SerializeOutputs(spent))
+}
+
+// spentOutputs reads the undo data of a connected block.
+func spentOutputs(batch Batch, block *Block) ([]TxOutput, error) {
+	value, err := batch.Get(undoKey(block.Hash))
+	if errors.Is(err, ErrNotFound) {
+		return nil, fmt.Errorf("Block %d has no undo data and cannot be disconnected", block.Height)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return DeserializeOutputs(value), nil
+}
+
+// Revert undoes Update for a block being disconnected from the tip: the
+// outputs it spent come back and the ones it created go away. Outputs both
+// created and spent inside the block are restored first and then removed.
+func (u UTXOSet) Revert(batch Batch, block *Block) error {
+	spent, err := spentOutputs(batch, block)
+	if err != nil {
+		return err
+	}
+
+	i := 0
+	for _, tx := range block.Transactions {
+		if tx.FlagCoinbaseTx() {
+			continue
+		}
+		for _, in := range tx.Inputs {
+			if i == len(spent) {
+				return fmt.Errorf("Undo data of block %d is too short", block.Height)
+			}
+			if err := batch.Put(utxoKey(in.ID, in.Out), spent[i].Serialize()); err != nil {
+				return err
+			}
+			i++
+		}
+	}
+	for _, tx := range block.Transactions {
+		if tx.FlagCoinbaseTx() {
+			if err := batch.Delete(coinbaseKey(tx.ID)); err != nil {
+				return err
+			}
+		}
+		for out_id := range tx.Outputs {
+			if err := batch.Delete(utxoKey(tx.ID, out_id)); err != nil {
+				return err
+			}
+		}
+	}
+	return batch.Delete(undoKey(block.Hash))
+}
+
+func (u UTXOSet) DeleteByPrefix(prefix []byte) {
+	err := deleteByPrefix(u.Blockchain.Database, prefix)
+	if err != nil {
+		log.Panic(err)
+	}
+}
@@ -0,0 +1,392 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+)
+
+// Consensus rules a block can break. Validation failures are reported as a
+// *RuleError wrapping one of these, so callers can tell them apart with
+// errors.Is.
+var (
+	ErrBadGenesis       = errors.New("not a valid genesis block")
+	ErrCheckpoint       = errors.New("conflicts with a checkpoint")
+	ErrBadParent        = errors.New("does not extend its parent")
+	ErrBadHeight        = errors.New("wrong height")
+	ErrBadVersion       = errors.New("unsupported version")
+	ErrBadTarget        = errors.New("wrong proof of work target")
+	ErrBadTimestamp     = errors.New("timestamp out of range")
+	ErrBadTxRoot        = errors.New("transactions do not match the header")
+	ErrBadHash          = errors.New("hash does not match the header")
+	ErrBadProofOfWork   = errors.New("proof of work above the target")
+	ErrBadSeal          = errors.New("not sealed by the expected signer")
+	ErrBadStake         = errors.New("stake kernel above the target")
+	ErrBlockTooLarge    = errors.New("block too large")
+	ErrNoTransactions   = errors.New("no transactions")
+	ErrBadCoinbase      = errors.New("missing or misplaced coinbase")
+	ErrCoinbaseTooLarge = errors.New("coinbase pays more than allowed")
+	ErrBadTransaction   = errors.New("malformed transaction")
+	ErrBadTxID          = errors.New("transaction ID does not match its hash")
+	ErrDuplicateTx      = errors.New("duplicate transaction")
+	ErrDoubleSpend      = errors.New("output spent twice in the block")
+	ErrMissingOutput    = errors.New("spends a missing or spent output")
+	ErrOverspend        = errors.New("outputs exceed inputs")
+	ErrImmatureSpend    = errors.New("spends an immature coinbase")
+	ErrBadSignature     = errors.New("invalid signature")
+)
+
+type RuleError struct {
+	Rule   error
+	Reason string
+}
+
+func (e *RuleError) Error() string {
+	return e.Reason
+}
+
+func (e *RuleError) Unwrap() error {
+	return e.Rule
+}
+
+func ruleError(rule error, format string, args ...interface{}) *RuleError {
+	return &RuleError{rule, fmt.Sprintf(format, args...)}
+}
+
+// checkBlockHeader checks that block extends parent, meets the rules the
+// chain sets for the next block and is sealed the way engine requires. A
+// nil parent means block must be a genesis block.
+func checkBlockHeader(block *Block, parent *Block, rules headerRules, engine ConsensusEngine) error {
+	if parent == nil {
+		if len(block.PreviousHash) != 0 || block.Height != 0 {
+			return ruleError(ErrBadGenesis, "block %x is not a genesis block", block.Hash)
+		}
+	} else {
+		if !bytes.Equal(block.PreviousHash, parent.Hash) {
+			return ruleError(ErrBadParent, "block %x does not extend %x", block.Hash, parent.Hash)
+		}
+		if block.Height != parent.Height+1 {
+			return ruleError(ErrBadHeight, "block %x has height %d, expected %d", block.Hash, block.Height, parent.Height+1)
+		}
+	}
+
+	// The height of a block, not the version it claims, decides which rules
+	// it is held to: blocks below the legacy height carry no version and
+	// every later block carries the current one.
+	if rules.legacy {
+		if block.Version != 0 {
+			return ruleError(ErrBadVersion, "block %x is below the legacy height but has version %d", block.Hash, block.Version)
+		}
+	} else if block.Version != currentBlockVersion {
+		return ruleError(ErrBadVersion, "block %x has version %d, expected %d", block.Hash, block.Version, currentBlockVersion)
+	}
+
+	// Blocks below the legacy height carry no target and were all mined at
+	// the legacy difficulty.
+	if rules.legacy {
+		if block.Bits != 0 {
+			return ruleError(ErrBadTarget, "block %x is below the legacy height but has target %08x", block.Hash, block.Bits)
+		}
+	} else if block.Bits != rules.bits {
+		return ruleError(ErrBadTarget, "block %x has target %08x, expected %08x", block.Hash, block.Bits, rules.bits)
+	}
+	if err := checkBlockTime(block, rules); err != nil {
+		return err
+	}
+
+	if !block.IsPruned() && len(block.TxRoot) > 0 && !bytes.Equal(block.TxRoot, block.HashTransactions()) {
+		return ruleError(ErrBadTxRoot, "block %x carries a transactions hash that does not match its transactions", block.Hash)
+	}
+
+	pow := CreateProofOfWork(block)
+	hash := sha256.Sum256(pow.ProcessData(block.Nonce))
+	if !bytes.Equal(hash[:], block.Hash) {
+		return ruleError(ErrBadHash, "block %x does not match its header hash %x", block.Hash, hash)
+	}
+	if err := checkCheckpoint(block, rules.checkpoint); err != nil {
+		return err
+	}
+	return engine.VerifyHeader(block)
+}
+
+// checkBlockBody runs the checks that need nothing but the block itself and
+// the parameters of its chain: its size, the shape of its transactions and
+// their IDs, where the coinbase is, and that no output is spent twice
+// within it.
+func checkBlockBody(block *Block, params *ChainParams) error {
+	if len(block.Transactions) == 0 {
+		return ruleError(ErrNoTransactions, "block has no transactions")
+	}
+	if size := len(block.Serialize()); size > maxBlockSize {
+		return ruleError(ErrBlockTooLarge, "block is %d bytes, more than the %d allowed", size, maxBlockSize)
+	}
+
+	if !params.legacy(block.Height) && !block.Transactions[0].FlagCoinbaseTx() {
+		return ruleError(ErrBadCoinbase, "first transaction is not a coinbase")
+	}
+
+	seen := make(map[string]bool)
+	spent := make(map[string]bool)
+	for i, tx := range block.Transactions {
+		if tx.FlagCoinbaseTx() && i > 0 {
+			return ruleError(ErrBadCoinbase, "transaction %d is a coinbase but only the first may be", i)
+		}
+		if len(tx.Inputs) == 0 || len(tx.Outputs) == 0 {
+			return ruleError(ErrBadTransaction, "transaction %d has no inputs or no outputs", i)
+		}
+		for out_id, out := range tx.Outputs {
+			// A coinbase may mint nothing once the supply cap is reached.
+			if out.Value < 0 || out.Value > MaxMoney || out.Value == 0 && !tx.FlagCoinbaseTx() {
+				return ruleError(ErrBadTransaction, "transaction %d output %d has value %d", i, out_id, out.Value)
+			}
+		}
+		if _, err := tx.outputValue(); err != nil {
+			return err
+		}
+
+		if hash := tx.hashFor(block.Version); !bytes.Equal(tx.ID, hash) {
+			return ruleError(ErrBadTxID, "transaction %d has ID %x but hashes to %x", i, tx.ID, hash)
+		}
+		if seen[hex.EncodeToString(tx.ID)] {
+			return ruleError(ErrDuplicateTx, "transaction %x appears twice", tx.ID)
+		}
+		seen[hex.EncodeToString(tx.ID)] = true
+
+		if tx.FlagCoinbaseTx() {
+			continue
+		}
+		for _, in := range tx.Inputs {
+			key := string(utxoKey(in.ID, in.Out))
+			if spent[key] {
+				return ruleError(ErrDoubleSpend, "transaction %x spends %x:%d, already spent earlier in the block", tx.ID, in.ID, in.Out)
+			}
+			spent[key] = true
+		}
+	}
+	return nil
+}
+
+// spentOutput finds the output an input of the block at height spends
+// among the transactions earlier in the same block, or through lookup.
+func spentOutput(in TxInput, height int, inBlock map[string]Transaction, lookup outputLookup) (outputEntry, bool) {
+	if prevTX, ok := inBlock[hex.EncodeToString(in.ID)]; ok {
+		if in.Out < 0 || in.Out >= len(prevTX.Outputs) {
+			return outputEntry{}, false
+		}
+		entry := outputEntry{prevTX.Outputs[in.Out], -1}
+		if prevTX.FlagCoinbaseTx() {
+			entry.CoinbaseHeight = height
+		}
+		return entry, true
+	}
+	if in.Out < 0 {
+		return outputEntry{}, false
+	}
+	entry, err := lookup(in.ID, in.Out)
+	return entry, err == nil
+}
+
+// transactionFee returns what the inputs of tx hold beyond its outputs.
+// Outputs worth more than the inputs break the rules, as do inputs spending
+// outputs that cannot be found and amounts out of range.
+func transactionFee(tx *Transaction, height int, inBlock map[string]Transaction, lookup outputLookup) (int, error) {
+	in := 0
+	for _, input := range tx.Inputs {
+		entry, found := spentOutput(input, height, inBlock, lookup)
+		if !found {
+			return 0, ruleError(ErrMissingOutput, "transaction %x spends unknown output %x:%d", tx.ID, input.ID, input.Out)
+		}
+		var ok bool
+		if in, ok = addValue(in, entry.Value); !ok {
+			return 0, ruleError(ErrBadTransaction, "transaction %x spends more than %d coins", tx.ID, MaxMoney)
+		}
+	}
+	out, err := tx.outputValue()
+	if err != nil {
+		return 0, err
+	}
+	if out > in {
+		return 0, ruleError(ErrOverspend, "transaction %x pays out %d but its inputs hold %d", tx.ID, out, in)
+	}
+	return in - out, nil
+}
+
+// checkBlockTransactions checks every transaction against the outputs it
+// spends: they must exist, hold at least what the transaction pays out and
+// belong to whoever signed it, and coinbase outputs must have matured. The
+// coinbase may pay no more than the subsidy params allow plus the fees of
+// the block, all within MaxMoney. Inputs may refer to earlier transactions
+// of the same block or to outputs found through lookup. Engines that tie blocks to the outputs they spend, like
+// proof of stake, get to check the block against them too. Signatures are
+// only checked if signatures is set.
+func checkBlockTransactions(block *Block, params *ChainParams, signatures bool, engine ConsensusEngine, lookup outputLookup) error {
+	inBlock := make(map[string]Transaction)
+	minted := 0
+	fees := 0
+
+	for _, tx := range block.Transactions {
+		if tx.FlagCoinbaseTx() {
+			value, err := tx.outputValue()
+			if err != nil {
+				return err
+			}
+			minted = value
+		} else {
+			fee, err := transactionFee(tx, block.Height, inBlock, lookup)
+			if err != nil {
+				return err
+			}
+			var ok bool
+			if fees, ok = addValue(fees, fee); !ok {
+				return ruleError(ErrBadTransaction, "transactions of block %x pay more than %d coins in fees", block.Hash, MaxMoney)
+			}
+
+			prevTXs := make(map[string]Transaction)
+			for _, in := range tx.Inputs {
+				out, _ := spentOutput(in, block.Height, inBlock, lookup)
+				if !params.legacy(block.Height) && !isMature(out.CoinbaseHeight, block.Height, params.CoinbaseMaturity) {
+					return ruleError(ErrImmatureSpend, "transaction %x spends %x:%d, minted at height %d, before it is %d blocks deep",
+						tx.ID, in.ID, in.Out, out.CoinbaseHeight, params.CoinbaseMaturity)
+				}
+				key := hex.EncodeToString(in.ID)
+				if prevTX, ok := inBlock[key]; ok {
+					prevTXs[key] = prevTX
+					continue
+				}
+				prevTX := prevTXs[key]
+				prevTX.ID = in.ID
+				for len(prevTX.Outputs) <= in.Out {
+					prevTX.Outputs = append(prevTX.Outputs, TxOutput{})
+				}
+				prevTX.Outputs[in.Out] = out.TxOutput
+				prevTXs[key] = prevTX
+			}
+			if signatures && !tx.verify(prevTXs, block.Version) {
+				return ruleError(ErrBadSignature, "transaction %x has an invalid signature", tx.ID)
+			}
+		}
+		inBlock[hex.EncodeToString(tx.ID)] = *tx
+	}
+
+	if subsidy := params.subsidy(block.Height); minted > subsidy+fees {
+		return ruleError(ErrCoinbaseTooLarge, "coinbase pays %d, more than the subsidy of %d plus %d in fees", minted, subsidy, fees)
+	}
+	if checker, ok := engine.(stakeChecker); ok {
+		return checker.checkStake(block, lookup)
+	}
+	return nil
+}
+
+// checkGenesis checks a genesis block received from outside, which has no
+// parent to vouch for it: its body, that its coinbase mints no more than the
+// genesis subsidy and premine, and that it is the genesis block params
+// describe, with their message, time and premine.
+func checkGenesis(block *Block, params *ChainParams, engine ConsensusEngine) error {
+	if err := checkBlockBody(block, params); err != nil {
+		return err
+	}
+	if len(block.Transactions) != 1 || !block.Transactions[0].FlagCoinbaseTx() {
+		return ruleError(ErrBadGenesis, "block %x holds more than a coinbase", block.Hash)
+	}
+
+	coinbase := block.Transactions[0]
+	if message := string(coinbase.Inputs[0].PubKey); message != params.GenesisMessage {
+		return ruleError(ErrBadGenesis, "block %x has genesis message %q, expected %q", block.Hash, message, params.GenesisMessage)
+	}
+	if params.GenesisTime != 0 && block.CreationTime != params.GenesisTime {
+		return ruleError(ErrBadGenesis, "block %x was created at %d, expected %d", block.Hash, block.CreationTime, params.GenesisTime)
+	}
+	premine := coinbase.Outputs[1:]
+	if len(premine) != len(params.Premine) {
+		return ruleError(ErrBadGenesis, "block %x pays %d premine outputs, expected %d", block.Hash, len(premine), len(params.Premine))
+	}
+	for i, allocation := range params.Premine {
+		expected := NewTXOutput(allocation.Amount, allocation.Address)
+		if premine[i].Value != expected.Value || !bytes.Equal(premine[i].PubKeyHash, expected.PubKeyHash) {
+			return ruleError(ErrBadGenesis, "block %x does not pay the premine of %d to %s", block.Hash, allocation.Amount, allocation.Address)
+		}
+	}
+
+	noOutputs := func(ID []byte, out int) (outputEntry, error) {
+		return outputEntry{}, ErrNotFound
+	}
+	return checkBlockTransactions(block, params, true, engine, noOutputs)
+}
+
+// ValidateBlock checks a block that would extend the current tip against
+// every consensus rule: the header against its parent, the block's own
+// structure, then its transactions against the UTXO set. Locally mined
+// blocks go through it before they are connected; received blocks go
+// through the same checks in AcceptBlock.
+func (chain *BlockChain) ValidateBlock(block *Block) error {
+	parent, err := chain.GetBlock(chain.LastHash)
+	if err != nil {
+		return err
+	}
+	rules, err := nextHeaderRules(chain.engine(), chain.params(), chain.Database, parent, chain.now())
+	if err != nil {
+		return err
+	}
+	if err := checkBlockHeader(block, parent, rules, chain.engine()); err != nil {
+		return err
+	}
+	if err := checkBlockBody(block, chain.params()); err != nil {
+		return err
+	}
+	return checkBlockTransactions(block, chain.params(), true, chain.engine(), unspentOutputs(chain.Database.Get))
+}
+
+// AcceptBlock validates a block received from outside, such as an imported
+// one, and stores it. The block may build on any known block; when its
+// branch ends up with more work than the main chain, the chain reorganizes
+// onto it, checking the transactions of every block it connects.
+func (chain *BlockChain) AcceptBlock(block *Block) error {
+	if err := chain.storeReceivedBlock(block); err != nil {
+		return err
+	}
+	return chain.activateBestChain()
+}
+
+// storeReceivedBlock runs the checks of AcceptBlock that need no UTXO set
+// and stores the block, without moving the tip. Imports store a whole file
+// this way before activating the best chain, so that the blocks below a
+// checkpoint are connected once the checkpointed block is known.
+func (chain *BlockChain) storeReceivedBlock(block *Block) error {
+	if _, err := chain.Database.Get(nodeKey(block.Hash)); err == nil {
+		return fmt.Errorf("block %x is already known", block.Hash)
+	}
+	parent, err := chain.Database.GetBlock(block.PreviousHash)
+	if errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("block %x does not build on a known block", block.Hash)
+	}
+	if err != nil {
+		return err
+	}
+	parentNode, err := readNode(chain.Database.Get, parent.Hash)
+	if err != nil {
+		return err
+	}
+	if parentNode.Status == StatusInvalid {
+		return fmt.Errorf("block %x builds on invalid block %x", block.Hash, parent.Hash)
+	}
+	if err := chain.params().checkCheckpointFork(block, chain.GetBestHeight()); err != nil {
+		return err
+	}
+
+	rules, err := nextHeaderRules(chain.engine(), chain.params(), chain.Database, parent, chain.now())
+	if err != nil {
+		return err
+	}
+	if err := checkBlockHeader(block, parent, rules, chain.engine()); err != nil {
+		return err
+	}
+	if err := checkBlockBody(block, chain.params()); err != nil {
+		return err
+	}
+
+	return chain.Database.Update(func(batch Batch) error {
+		return storeBlock(batch, block, chain.engine())
+	})
+}
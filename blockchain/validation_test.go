@@ -0,0 +1,89 @@
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"math"
+	"testing"
+)
+
+func TestTransactionFees(t *testing.T) {
+	chain, owner := newTestChain(t, &RegtestParams)
+	address := string(owner.Address())
+	genesis := mustBlock(t, chain, chain.LastHash)
+	coinbase := genesis.Transactions[0]
+	held := coinbase.Outputs[0].Value
+	subsidy := chain.params().subsidy(1)
+
+	pay := func(values ...int) *Transaction {
+		var outputs []TxOutput
+		for _, value := range values {
+			outputs = append(outputs, *NewTXOutput(value, testAddress()))
+		}
+		return spendTo(coinbase, 0, owner, owner, outputs...)
+	}
+	withReward := func(reward int, txs ...*Transaction) []*Transaction {
+		return append([]*Transaction{CreateCoinbaseTx(address, "", reward)}, txs...)
+	}
+	tests := []struct {
+		name string
+		txs  []*Transaction
+		want error
+	}{
+		{"fee claimed", withReward(subsidy+10, pay(held-10)), nil},
+		{"fee left", withReward(subsidy, pay(held-10)), nil},
+		{"more than the fee claimed", withReward(subsidy+11, pay(held-10)), ErrCoinbaseTooLarge},
+		{"overspend", withReward(subsidy, pay(held+1)), ErrOverspend},
+		{"overspend in change", withReward(subsidy, pay(held-10, 11)), ErrOverspend},
+		{"overflowing outputs", withReward(subsidy, pay(math.MaxInt, math.MaxInt, 3)), ErrBadTransaction},
+		{"negative output", withReward(subsidy, pay(held+1, -1)), ErrBadTransaction},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := chain.ValidateBlock(sealTransactions(t, chain, genesis, 0, test.txs...))
+			if !errors.Is(err, test.want) {
+				t.Errorf("got %v, want %v", err, test.want)
+			}
+		})
+	}
+
+	if _, err := chain.MineBlock(context.Background(), address, []*Transaction{pay(math.MaxInt, math.MaxInt, 3)}); !errors.Is(err, ErrBadTransaction) {
+		t.Errorf("mining overflowing outputs got %v, want %v", err, ErrBadTransaction)
+	}
+	block, err := chain.MineBlock(context.Background(), address, []*Transaction{pay(held - 10)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reward := block.Transactions[0].Outputs[0].Value; reward != subsidy+10 {
+		t.Errorf("mined coinbase pays %d, want the subsidy of %d plus a fee of 10", reward, subsidy)
+	}
+}
+
+func TestBlockStartsWithCoinbase(t *testing.T) {
+	chain, owner := newTestChain(t, &RegtestParams)
+	address := string(owner.Address())
+	genesis := mustBlock(t, chain, chain.LastHash)
+	payment := spend(genesis.Transactions[0], 0, owner, owner, testAddress())
+	coinbase := func() *Transaction {
+		return CreateCoinbaseTx(address, "", chain.params().subsidy(1))
+	}
+
+	tests := []struct {
+		name string
+		txs  []*Transaction
+		want error
+	}{
+		{"no coinbase", []*Transaction{payment}, ErrBadCoinbase},
+		{"coinbase second", []*Transaction{payment, coinbase()}, ErrBadCoinbase},
+		{"two coinbases", []*Transaction{coinbase(), coinbase()}, ErrBadCoinbase},
+		{"coinbase first", []*Transaction{coinbase(), payment}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := chain.ValidateBlock(sealTransactions(t, chain, genesis, 0, test.txs...))
+			if !errors.Is(err, test.want) {
+				t.Errorf("got %v, want %v", err, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,128 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// Verification levels, each one including the checks of the levels below.
+const (
+	VerifyHeaders = iota
+	VerifyTransactionIDs
+	VerifySignatures
+	VerifyUTXO
+)
+
+type BlockError struct {
+	Height int
+	Hash   []byte
+	Err    error
+}
+
+func (e *BlockError) Error() string {
+	return fmt.Sprintf("block %d (%x): %s", e.Height, e.Hash, e.Err)
+}
+
+func (e *BlockError) Unwrap() error {
+	return e.Err
+}
+
+// VerifyChain replays the chain from genesis to tip, running the checks up
+// to level on every block. It returns the number of blocks that passed and
+// a *BlockError for the first one that did not.
+func (chain *BlockChain) VerifyChain(level int) (int, error) {
+	if level > VerifyHeaders && chain.IsPruned() {
+		return 0, fmt.Errorf("Only level %d is available on a pruned chain: %w", VerifyHeaders, ErrPruned)
+	}
+
+	var hashes [][]byte
+	hash := chain.LastHash
+	for {
+		block, err := chain.GetBlock(hash)
+		if err != nil {
+			return 0, &BlockError{-1, hash, err}
+		}
+		hashes = append(hashes, hash)
+		if len(block.PreviousHash) == 0 {
+			break
+		}
+		hash = block.PreviousHash
+	}
+
+	replay := &BlockChain{nil, NewMemoryStore(), Miner{}, nil, nil}
+	var parent *Block
+	count := 0
+
+	for i := len(hashes) - 1; i >= 0; i-- {
+		block, err := chain.GetBlock(hashes[i])
+		if err != nil {
+			return count, &BlockError{len(hashes) - 1 - i, hashes[i], err}
+		}
+		fail := func(err error) (int, error) {
+			return count, &BlockError{block.Height, block.Hash, err}
+		}
+
+		rules, err := nextHeaderRules(chain.engine(), chain.params(), chain.Database, parent, chain.now())
+		if err != nil {
+			return fail(err)
+		}
+		if err := checkBlockHeader(block, parent, rules, chain.engine()); err != nil {
+			return fail(err)
+		}
+		indexed, err := chain.GetBlockHash(block.Height)
+		if err != nil || !bytes.Equal(indexed, block.Hash) {
+			return fail(errors.New("height index does not point to this block"))
+		}
+		if level >= VerifyTransactionIDs {
+			if err := checkBlockBody(block, chain.params()); err != nil {
+				return fail(err)
+			}
+		}
+		if level >= VerifySignatures {
+			if err := checkBlockTransactions(block, chain.params(), true, chain.engine(), chain.outputsBefore(block.Height)); err != nil {
+				return fail(err)
+			}
+		}
+		if level >= VerifyUTXO {
+			err := replay.Database.Update(func(batch Batch) error {
+				return applyBlock(batch, block)
+			})
+			if err != nil {
+				return fail(err)
+			}
+		}
+
+		parent = block
+		count++
+	}
+
+	if level >= VerifyUTXO {
+		stored := make(map[string]TxOutput)
+		err := chain.Database.Iterate(utxoPrefix, false, func(key, value []byte) bool {
+			stored[string(key)] = DeserializeOutput(value)
+			return true
+		})
+		if err != nil {
+			return count, err
+		}
+		// Every replayed output must be stored as it is, and with as many
+		// outputs on both sides, none can be stored that was not replayed.
+		matches := UTXOSet{replay}.CountOutputs() == len(stored)
+		if matches {
+			err = replay.Database.Iterate(utxoPrefix, false, func(key, value []byte) bool {
+				out, ok := stored[string(key)]
+				expected := DeserializeOutput(value)
+				matches = ok && out.Value == expected.Value && bytes.Equal(out.PubKeyHash, expected.PubKeyHash)
+				return matches
+			})
+			if err != nil {
+				return count, err
+			}
+		}
+		if !matches {
+			return count, &BlockError{parent.Height, parent.Hash, errors.New("UTXO set does not match the blocks, run reindexutxo")}
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,85 @@
+package blockchain
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestVerifyChainComparesUTXOSet(t *testing.T) {
+	tests := []struct {
+		name   string
+		tamper func(batch Batch, genesis *Block) error
+	}{
+		{"extra output", func(batch Batch, genesis *Block) error {
+			return batch.Put(utxoKey([]byte("no such transaction"), 0), NewTXOutput(5, testAddress()).Serialize())
+		}},
+		{"missing output", func(batch Batch, genesis *Block) error {
+			return batch.Delete(utxoKey(genesis.Transactions[0].ID, 0))
+		}},
+		{"changed output", func(batch Batch, genesis *Block) error {
+			return batch.Put(utxoKey(genesis.Transactions[0].ID, 0), NewTXOutput(5, testAddress()).Serialize())
+		}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			chain, owner := newTestChain(t, &RegtestParams)
+			genesis := mustBlock(t, chain, chain.LastHash)
+			if err := chain.AcceptBlock(sealBlock(t, chain, genesis, string(owner.Address()))); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := chain.VerifyChain(VerifyUTXO); err != nil {
+				t.Fatalf("before tampering: %v", err)
+			}
+
+			err := chain.Database.Update(func(batch Batch) error {
+				return test.tamper(batch, genesis)
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := chain.VerifyChain(VerifySignatures); err != nil {
+				t.Errorf("level %d does not look at the UTXO set but failed: %v", VerifySignatures, err)
+			}
+			if _, err := chain.VerifyChain(VerifyUTXO); err == nil {
+				t.Errorf("level %d passed with a tampered UTXO set", VerifyUTXO)
+			}
+		})
+	}
+}
+
+func TestVerifyChainSpendsOnlyEarlierOutputs(t *testing.T) {
+	chain, owner := newTestChain(t, &RegtestParams)
+	genesis := mustBlock(t, chain, chain.LastHash)
+
+	// The first transaction spends the output of the second, which does not
+	// exist yet when it is spent. Such a block cannot be connected, so it is
+	// written to the store as if it had been.
+	created := spend(genesis.Transactions[0], 0, owner, owner, string(owner.Address()))
+	early := spend(created, 0, owner, owner, testAddress())
+	coinbase := CreateCoinbaseTx(string(owner.Address()), "", chain.params().subsidy(1))
+	block := sealTransactions(t, chain, genesis, 0, coinbase, early, created)
+	err := chain.Database.Update(func(batch Batch) error {
+		if err := storeBlock(batch, block, chain.engine()); err != nil {
+			return err
+		}
+		if err := indexTransactions(batch, block); err != nil {
+			return err
+		}
+		if err := batch.Put(heightKey(block.Height), block.Hash); err != nil {
+			return err
+		}
+		return batch.SetTip(block.Hash)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain.LastHash = block.Hash
+
+	if _, err := chain.VerifyChain(VerifyTransactionIDs); err != nil {
+		t.Fatalf("level %d: %v", VerifyTransactionIDs, err)
+	}
+	count, err := chain.VerifyChain(VerifySignatures)
+	if !errors.Is(err, ErrMissingOutput) || count != 1 {
+		t.Errorf("level %d verified %d blocks and returned %v, want 1 block and %v", VerifySignatures, count, err, ErrMissingOutput)
+	}
+}
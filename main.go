@@ -1,32 +1,100 @@
 package main
 
 import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"runtime"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gustavoddoki/GoBlockchain/blockchain"
 	"github.com/gustavoddoki/GoBlockchain/wallet"
 )
 
-type CommandLine struct{}
+const dataDirEnv = "GOBLOCKCHAIN_DATADIR"
+
+type CommandLine struct {
+	dataDir string
+	prune   int
+	params  *blockchain.ChainParams
+}
 
 func (cli *CommandLine) printUsage() {
-	fmt.Println("Usage:")
+	fmt.Println("Usage: [-datadir DIR] [-params FILE | -regtest] [-checkpoints CHECKPOINTS] COMMAND")
+	fmt.Printf(" -datadir DIR - Directory holding the chain and wallets (default ./tmp, or $%s)\n", dataDirEnv)
+	fmt.Println(" -params FILE - JSON file with the parameters of the network to use instead of the main network, kept in a subdirectory of DIR named after it")
+	fmt.Println(" -regtest - Use the local test network, where blocks are mined instantly, kept in DIR/regtest")
+	fmt.Println(" -checkpoints HEIGHT:HASH,... - Blocks the chain must contain, on top of the checkpoints of the network")
+	fmt.Println(" -prune N - Keep transactions only for the last N blocks, discarding older ones")
+	fmt.Println("Commands:")
 	fmt.Println(" getbalance -address ADDRESS - get the balance for an address")
-	fmt.Println(" createblockchain -address ADDRESS creates a blockchain and sends genesis reward to address")
+	fmt.Println(" createblockchain -address ADDRESS [-txindex] [-threads N] [-halving N] [-maxsupply N] [-consensus pow|poa|pos] [-authorities ADDRESSES] creates a blockchain and sends genesis reward to address")
 	fmt.Println(" printchain - Prints the blocks in the chain")
-	fmt.Println(" send -from FROM -to TO -amount AMOUNT - Send amount of coins")
+	fmt.Println(" send -from FROM -to TO -amount AMOUNT [-fee FEE] [-threads N] - Send amount of coins, paying fee to the miner and mining the block on N threads (default: all CPUs)")
+	fmt.Println(" generate -blocks N -address ADDRESS - Mines N blocks right away, paying their rewards to address")
+	fmt.Println(" stake -address ADDRESS - Stakes the outputs of address on a proof of stake chain, adding blocks until interrupted")
 	fmt.Println(" createwallet - Creates a new Wallet")
 	fmt.Println(" listaddresses - Lists the addresses in our wallet file")
+	fmt.Println(" reindexutxo - Rebuilds the UTXO set and the address index")
+	fmt.Println(" getblock -height HEIGHT | -hash HASH - Prints a single block")
+	fmt.Println(" getblockcount - Prints the height of the most recent block")
+	fmt.Println(" reindextx - Builds and enables the transaction index")
+	fmt.Println(" gettransaction -id TXID - Prints a transaction and the block containing it")
+	fmt.Println(" history -address ADDRESS [-skip N] [-count N] - Lists the transactions of an address, most recent first")
+	fmt.Println(" verifychain [-level N] - Checks every block from genesis: 0 headers, 1 transaction IDs, 2 signatures, 3 UTXO set")
+	fmt.Println(" exportchain -file FILE - Writes every block to a bootstrap file")
+	fmt.Println(" migrate [-dry-run] - Upgrades the database to the current schema, or reports what would change")
+	fmt.Println(" importchain -file FILE [-consensus pow|poa|pos] [-authorities ADDRESSES] - Validates and loads a bootstrap file, adding its missing blocks to an existing chain")
+	fmt.Println(" getchaintips - Lists the tips of all known branches and their status")
+	fmt.Println(" gettxproof -id TXID - Prints a proof that a transaction is included in its block")
+	fmt.Println(" verifytxproof -proof PROOF - Checks a proof printed by gettxproof")
+}
+
+func (cli *CommandLine) continueChain(address string) *blockchain.BlockChain {
+	chain := blockchain.ContinueBlockChain(address, cli.dataDir)
+	if chain.Params.Magic != cli.params.Magic {
+		fmt.Printf("The data directory holds a chain of the %s network, not %s\n", chain.Params.Name, cli.params.Name)
+		chain.Database.Close()
+		runtime.Goexit()
+	}
+	chain.Params.Checkpoints = cli.params.Checkpoints
+	cli.applyPrune(chain)
+	return chain
+}
+
+func (cli *CommandLine) applyPrune(chain *blockchain.BlockChain) {
+	if cli.prune == 0 || cli.prune == chain.PruneDepth() {
+		return
+	}
+	pruned, err := chain.SetPruneDepth(cli.prune)
+	if err != nil {
+		chain.Database.Close()
+		fmt.Println(err)
+		runtime.Goexit()
+	}
+	fmt.Printf("Pruning to the last %d blocks, %d blocks pruned\n", cli.prune, pruned)
 }
 
-func (cli *CommandLine) validateArgs() {
-	if len(os.Args) < 2 {
+func (cli *CommandLine) exitIfPruned(chain *blockchain.BlockChain) {
+	if chain.IsPruned() {
+		chain.Database.Close()
+		fmt.Println("This command needs the full blocks, but the chain has been pruned.")
+		runtime.Goexit()
+	}
+}
+
+func (cli *CommandLine) validateArgs(args []string) {
+	if len(args) < 1 {
 		cli.printUsage()
 		runtime.Goexit()
 	}
@@ -34,37 +102,218 @@ func (cli *CommandLine) validateArgs() {
 
 func (cli *CommandLine) printChain() {
 
-	chain := blockchain.ContinueBlockChain("")
+	chain := cli.continueChain("")
 	defer chain.Database.Close()
 	iter := chain.Iterator()
 
 	for {
 		block := iter.Next()
+		cli.printBlock(block, chain.Engine)
 
-		fmt.Printf("Previous hash: %x\n", block.PreviousHash)
-		fmt.Printf("Hash: %x\n", block.Hash)
-		fmt.Printf("Creation time: %s\n", time.Unix(int64(block.CreationTime), 0))
+		if len(block.PreviousHash) == 0 {
+			break
+		}
+	}
+}
 
-		pow := blockchain.CreateProofOfWork(block)
+func (cli *CommandLine) printBlock(block *blockchain.Block, engine blockchain.ConsensusEngine) {
+	fmt.Printf("Height: %d\n", block.Height)
+	fmt.Printf("Previous hash: %x\n", block.PreviousHash)
+	fmt.Printf("Hash: %x\n", block.Hash)
+	fmt.Printf("Creation time: %s\n", time.Unix(int64(block.CreationTime), 0))
+	if len(block.PubKey) > 0 {
+		fmt.Printf("Signed by: %s\n", wallet.PubKeyHashToAddress(wallet.PublicKeyHash(block.PubKey)))
+	} else {
+		fmt.Printf("Target: %x\n", block.Target())
+	}
+	fmt.Printf("Seal: %s\n", strconv.FormatBool(engine.VerifyHeader(block) == nil))
+	if block.IsPruned() {
+		fmt.Println("Transactions: pruned")
+	}
+	for _, tx := range block.Transactions {
+		fmt.Println(tx)
+	}
+	fmt.Println()
+}
+
+func (cli *CommandLine) getBlock(height int, hash string) {
+	chain := cli.continueChain("")
+	defer chain.Database.Close()
 
-		fmt.Printf("PoW: %s\n", strconv.FormatBool(pow.Validate()))
-		for _, tx := range block.Transactions {
-			fmt.Println(tx)
+	var block *blockchain.Block
+	var err error
+	if hash != "" {
+		var blockHash []byte
+		blockHash, err = hex.DecodeString(hash)
+		if err != nil {
+			log.Panic(err)
 		}
+		block, err = chain.GetBlock(blockHash)
+	} else {
+		block, err = chain.GetBlockByHeight(height)
+	}
+	if err != nil {
+		fmt.Println(err)
+		runtime.Goexit()
+	}
+	cli.printBlock(block, chain.Engine)
+}
+
+func (cli *CommandLine) getBlockCount() {
+	chain := cli.continueChain("")
+	defer chain.Database.Close()
+
+	fmt.Println(chain.GetBestHeight())
+}
+
+func (cli *CommandLine) getChainTips() {
+	chain := cli.continueChain("")
+	defer chain.Database.Close()
+
+	tips, err := chain.ChainTips()
+	if err != nil {
+		log.Panic(err)
+	}
+	for _, tip := range tips {
+		fmt.Printf("Height: %d\n", tip.Height)
+		fmt.Printf("Hash: %x\n", tip.Hash)
+		fmt.Printf("Branch length: %d\n", tip.BranchLength)
+		fmt.Printf("Chain work: %x\n", tip.Work)
+		fmt.Printf("Status: %s\n", tip.Status)
 		fmt.Println()
+	}
+}
 
-		if len(block.PreviousHash) == 0 {
-			break
+func (cli *CommandLine) miner(threads int) blockchain.Miner {
+	return blockchain.Miner{
+		Threads: threads,
+		Hashrate: func(hashesPerSecond float64) {
+			fmt.Printf("Mining at %.0f hashes/s\n", hashesPerSecond)
+		},
+	}
+}
+
+// walletKeys returns the private keys of every wallet in the data
+// directory, for signing blocks on proof of authority and proof of stake
+// chains.
+func (cli *CommandLine) walletKeys() []ecdsa.PrivateKey {
+	wallets, err := wallet.CreateWallets(cli.dataDir)
+	if err != nil {
+		log.Panic(err)
+	}
+	var keys []ecdsa.PrivateKey
+	for _, w := range wallets.Wallets {
+		keys = append(keys, w.PrivateKey)
+	}
+	return keys
+}
+
+// sealWith sets up the chain's engine to seal blocks on this node.
+func (cli *CommandLine) sealWith(chain *blockchain.BlockChain, threads int) {
+	switch engine := chain.Engine.(type) {
+	case blockchain.Miner:
+		chain.Engine = cli.miner(threads)
+	case blockchain.AuthorityEngine:
+		engine.Keys = cli.walletKeys()
+		chain.Engine = engine
+	case blockchain.StakeEngine:
+		engine.Keys = cli.walletKeys()
+		chain.Engine = engine
+	}
+}
+
+// stakeBlock tries to stake a block of transactions with the outputs of w
+// once a second, until one of them wins or ctx is done.
+func (cli *CommandLine) stakeBlock(ctx context.Context, chain *blockchain.BlockChain, w *wallet.Wallet, transactions []*blockchain.Transaction) (*blockchain.Block, error) {
+	for {
+		block, err := chain.Stake(ctx, w, transactions)
+		if !errors.Is(err, blockchain.ErrNoKernel) {
+			return block, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// addCheckpoints adds HEIGHT:HASH pairs to the checkpoints of the network.
+func (cli *CommandLine) addCheckpoints(checkpoints string) {
+	params := *cli.params
+	params.Checkpoints = make(map[int]string)
+	for height, hash := range cli.params.Checkpoints {
+		params.Checkpoints[height] = hash
+	}
+	for _, checkpoint := range strings.Split(checkpoints, ",") {
+		fields := strings.SplitN(checkpoint, ":", 2)
+		height, err := strconv.Atoi(fields[0])
+		if err != nil || len(fields) != 2 {
+			fmt.Printf("Invalid checkpoint %q, expected HEIGHT:HASH\n", checkpoint)
+			runtime.Goexit()
 		}
+		params.Checkpoints[height] = fields[1]
+	}
+	if err := params.Validate(); err != nil {
+		fmt.Println(err)
+		runtime.Goexit()
+	}
+	cli.params = &params
+}
+
+// withConsensus returns params running the consensus engine named by
+// consensus, signed by the comma-separated authorities on a proof of
+// authority network.
+func withConsensus(params blockchain.ChainParams, consensus string, authorities string) *blockchain.ChainParams {
+	params.Consensus = consensus
+	params.Authorities = nil
+	if authorities != "" {
+		params.Authorities = strings.Split(authorities, ",")
+	}
+	if err := params.Validate(); err != nil {
+		fmt.Println(err)
+		runtime.Goexit()
 	}
+	return &params
 }
 
-func (cli *CommandLine) createBlockChain(address string) {
+func (cli *CommandLine) createBlockChain(address string, txIndex bool, threads int, params *blockchain.ChainParams) {
 	if !wallet.ValidateAddress(address) {
 		log.Panic("Invalid address.")
 	}
+	if err := params.Validate(); err != nil {
+		fmt.Println(err)
+		runtime.Goexit()
+	}
 
-	chain := blockchain.CreateBlockchain(address)
+	engine, err := params.Engine()
+	if err != nil {
+		log.Panic(err)
+	}
+	switch follower := engine.(type) {
+	case blockchain.Miner:
+		engine = cli.miner(threads)
+	case blockchain.AuthorityEngine:
+		follower.Keys = cli.walletKeys()
+		engine = follower
+	case blockchain.StakeEngine:
+		wallets, err := wallet.CreateWallets(cli.dataDir)
+		if err != nil {
+			log.Panic(err)
+		}
+		if _, ok := wallets.Wallets[address]; !ok {
+			fmt.Printf("The genesis block of a proof of stake chain is signed by %s, which is not in the wallet file\n", address)
+			runtime.Goexit()
+		}
+		follower.Keys = []ecdsa.PrivateKey{wallets.GetWallet(address).PrivateKey}
+		engine = follower
+	}
+
+	chain := blockchain.CreateBlockchain(address, cli.dataDir, engine, params)
+	if txIndex {
+		chain.ReindexTransactions()
+	}
+	cli.applyPrune(chain)
 	chain.Database.Close()
 	fmt.Println("Blockchain created!")
 }
@@ -74,22 +323,21 @@ func (cli *CommandLine) getBalance(address string) {
 		log.Panic("Invalid address.")
 	}
 
-	chain := blockchain.ContinueBlockChain(address)
+	chain := cli.continueChain(address)
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
 	defer chain.Database.Close()
 
-	balance := 0
 	pubKeyHash := wallet.Base58Decode([]byte(address))
 	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
-	UTX0s := chain.FindUXT0(pubKeyHash)
-
-	for _, out := range UTX0s {
-		balance += out.Value
-	}
+	balance, immature := UTXOSet.Balance(pubKeyHash)
 
 	fmt.Printf("Balance of %s: %d\n", address, balance)
+	if immature > 0 {
+		fmt.Printf("Immature mining rewards: %d\n", immature)
+	}
 }
 
-func (cli *CommandLine) send(from string, to string, amount int) {
+func (cli *CommandLine) send(from string, to string, amount int, fee int, threads int) {
 	if !wallet.ValidateAddress(to) {
 		log.Panic("Invalid address.")
 	}
@@ -97,16 +345,313 @@ func (cli *CommandLine) send(from string, to string, amount int) {
 		log.Panic("Invalid address.")
 	}
 
-	chain := blockchain.ContinueBlockChain(from)
+	wallets, err := wallet.CreateWallets(cli.dataDir)
+	if err != nil {
+		log.Panic(err)
+	}
+	w := wallets.GetWallet(from)
+
+	chain := cli.continueChain(from)
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
 	defer chain.Database.Close()
 
-	tx := blockchain.CreateTransaction(from, to, amount, chain)
-	chain.AddBlock([]*blockchain.Transaction{tx})
+	tx := blockchain.CreateTransaction(&w, to, amount, fee, &UTXOSet)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	cli.sealWith(chain, threads)
+	if _, ok := chain.Engine.(blockchain.StakeEngine); ok {
+		_, err = cli.stakeBlock(ctx, chain, &w, []*blockchain.Transaction{tx})
+		if err != nil {
+			fmt.Println("Staking stopped:", err)
+			return
+		}
+	} else {
+		_, err = chain.MineBlock(ctx, from, []*blockchain.Transaction{tx})
+		if err != nil {
+			fmt.Println("Mining stopped:", err)
+			return
+		}
+	}
 	fmt.Println("Transaction executed successfully!")
 }
 
+func (cli *CommandLine) generate(blocks int, address string) {
+	if !wallet.ValidateAddress(address) {
+		log.Panic("Invalid address.")
+	}
+
+	chain := cli.continueChain(address)
+	defer chain.Database.Close()
+	if _, ok := chain.Engine.(blockchain.StakeEngine); ok {
+		fmt.Println("Blocks of a proof of stake chain are added with stake")
+		runtime.Goexit()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	cli.sealWith(chain, 0)
+	if miner, ok := chain.Engine.(blockchain.Miner); ok {
+		miner.Hashrate = nil
+		chain.Engine = miner
+	}
+	for i := 0; i < blocks; i++ {
+		block, err := chain.MineBlock(ctx, address, nil)
+		if err != nil {
+			fmt.Printf("Mining stopped after %d blocks: %s\n", i, err)
+			return
+		}
+		fmt.Printf("%x\n", block.Hash)
+	}
+}
+
+func (cli *CommandLine) stake(address string) {
+	if !wallet.ValidateAddress(address) {
+		log.Panic("Invalid address.")
+	}
+
+	wallets, err := wallet.CreateWallets(cli.dataDir)
+	if err != nil {
+		log.Panic(err)
+	}
+	if _, ok := wallets.Wallets[address]; !ok {
+		fmt.Printf("%s is not in the wallet file\n", address)
+		runtime.Goexit()
+	}
+	w := wallets.GetWallet(address)
+
+	chain := cli.continueChain(address)
+	defer chain.Database.Close()
+	if _, ok := chain.Engine.(blockchain.StakeEngine); !ok {
+		fmt.Println("The chain does not use proof of stake")
+		runtime.Goexit()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	cli.sealWith(chain, 0)
+	fmt.Printf("Staking with %s, press Ctrl-C to stop\n", address)
+	for {
+		block, err := cli.stakeBlock(ctx, chain, &w, nil)
+		if err != nil {
+			fmt.Println("Staking stopped:", err)
+			return
+		}
+		fmt.Printf("Staked block %d: %x\n", block.Height, block.Hash)
+	}
+}
+
+func (cli *CommandLine) reindexUTXO() {
+	chain := cli.continueChain("")
+	defer chain.Database.Close()
+	cli.exitIfPruned(chain)
+	UTXOSet := blockchain.UTXOSet{Blockchain: chain}
+	UTXOSet.Reindex()
+
+	count := UTXOSet.CountOutputs()
+	fmt.Printf("Done! There are %d unspent outputs in the UTXO set.\n", count)
+}
+
+func (cli *CommandLine) reindexTx() {
+	chain := cli.continueChain("")
+	defer chain.Database.Close()
+	cli.exitIfPruned(chain)
+
+	count := chain.ReindexTransactions()
+	fmt.Printf("Done! %d transactions indexed.\n", count)
+}
+
+func (cli *CommandLine) getTransaction(id string) {
+	chain := cli.continueChain("")
+	defer chain.Database.Close()
+
+	txID, err := hex.DecodeString(id)
+	if err != nil {
+		log.Panic(err)
+	}
+	tx, block, err := chain.FindTransactionBlock(txID)
+	if err != nil {
+		fmt.Println(err)
+		runtime.Goexit()
+	}
+
+	fmt.Println(tx)
+	fmt.Printf("Block hash: %x\n", block.Hash)
+	fmt.Printf("Block height: %d\n", block.Height)
+	fmt.Printf("Confirmations: %d\n", chain.GetBestHeight()-block.Height+1)
+}
+
+func (cli *CommandLine) getTxProof(id string) {
+	chain := cli.continueChain("")
+	defer chain.Database.Close()
+
+	txID, err := hex.DecodeString(id)
+	if err != nil {
+		log.Panic(err)
+	}
+	proof, err := chain.TransactionProof(txID)
+	if err != nil {
+		fmt.Println(err)
+		runtime.Goexit()
+	}
+	fmt.Printf("%x\n", proof.Serialize())
+}
+
+func (cli *CommandLine) verifyTxProof(encoded string) {
+	data, err := hex.DecodeString(encoded)
+	if err != nil {
+		log.Panic(err)
+	}
+	proof, err := blockchain.DeserializeMerkleProof(data)
+	if err != nil {
+		fmt.Println("Malformed proof:", err)
+		runtime.Goexit()
+	}
+	if !blockchain.DBexists(cli.dataDir) {
+		if err := proof.Verify(blockchain.Miner{}); err != nil {
+			fmt.Println("Invalid proof:", err)
+			runtime.Goexit()
+		}
+		fmt.Printf("Transaction %x is included in block %x at height %d\n", proof.TxID, proof.Header.Hash, proof.Header.Height)
+		fmt.Println("No local chain to check the block against")
+		return
+	}
+	chain := cli.continueChain("")
+	defer chain.Database.Close()
+	if err := proof.Verify(chain.Engine); err != nil {
+		fmt.Println("Invalid proof:", err)
+		runtime.Goexit()
+	}
+	fmt.Printf("Transaction %x is included in block %x at height %d\n", proof.TxID, proof.Header.Hash, proof.Header.Height)
+	hash, err := chain.GetBlockHash(proof.Header.Height)
+	if err == nil && bytes.Equal(hash, proof.Header.Hash) {
+		fmt.Printf("The block is in the local chain with %d confirmations\n", chain.GetBestHeight()-proof.Header.Height+1)
+	} else {
+		fmt.Println("The block is not in the local chain")
+	}
+}
+
+func (cli *CommandLine) history(address string, skip int, count int) {
+	if !wallet.ValidateAddress(address) {
+		log.Panic("Invalid address.")
+	}
+
+	chain := cli.continueChain("")
+	defer chain.Database.Close()
+
+	pubKeyHash := wallet.Base58Decode([]byte(address))
+	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-4]
+
+	for _, record := range chain.AddressHistory(pubKeyHash, skip, count) {
+		fmt.Printf("Transaction %x\n", record.TxID)
+		fmt.Printf("  Height: %d (%s)\n", record.Height, time.Unix(record.Timestamp, 0))
+		fmt.Printf("  Received: %d\n", record.Received)
+		fmt.Printf("  Sent: %d\n", record.Sent)
+		for _, out := range record.Counterparties {
+			fmt.Printf("  Counterparty: %s (%d)\n", wallet.PubKeyHashToAddress(out.PubKeyHash), out.Value)
+		}
+		fmt.Println()
+	}
+}
+
+func (cli *CommandLine) verifyChain(level int) {
+	chain := cli.continueChain("")
+	defer chain.Database.Close()
+
+	count, err := chain.VerifyChain(level)
+	if err != nil {
+		fmt.Printf("Verification failed after %d blocks: %s\n", count, err)
+		runtime.Goexit()
+	}
+	fmt.Printf("Verified %d blocks at level %d\n", count, level)
+}
+
+func (cli *CommandLine) migrate(dryRun bool) {
+	if !blockchain.DBexists(cli.dataDir) {
+		fmt.Println("Blockchain does not exist.")
+		runtime.Goexit()
+	}
+
+	store, err := blockchain.OpenBadgerStore(blockchain.DBPath(cli.dataDir))
+	if err != nil {
+		log.Panic(err)
+	}
+	defer store.Close()
+
+	report, err := blockchain.Migrate(store, dryRun)
+	if err != nil {
+		log.Panic(err)
+	}
+	if len(report) == 0 {
+		fmt.Printf("Database is up to date (schema version %d)\n", blockchain.CurrentSchemaVersion)
+		return
+	}
+	if dryRun {
+		fmt.Println("Dry run, nothing was changed. Pending migrations:")
+	}
+	for _, line := range report {
+		fmt.Println(line)
+	}
+}
+
+func (cli *CommandLine) exportChain(path string) {
+	chain := cli.continueChain("")
+	defer chain.Database.Close()
+	cli.exitIfPruned(chain)
+
+	file, err := os.Create(path)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer file.Close()
+
+	count, err := chain.ExportChain(file)
+	if err != nil {
+		log.Panic(err)
+	}
+	fmt.Printf("Exported %d blocks to %s\n", count, path)
+}
+
+func (cli *CommandLine) importChain(path string, params *blockchain.ChainParams) {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Panic(err)
+	}
+	defer file.Close()
+
+	if blockchain.DBexists(cli.dataDir) {
+		chain := cli.continueChain("")
+		defer chain.Database.Close()
+
+		count, err := chain.ImportBlocks(file)
+		if err != nil {
+			fmt.Printf("Import failed after adding %d blocks: %s\n", count, err)
+		} else {
+			fmt.Printf("Added %d new blocks from %s\n", count, path)
+		}
+		fmt.Printf("Chain tip is now %x at height %d\n", chain.LastHash, chain.GetBestHeight())
+		return
+	}
+
+	dbPath := blockchain.DBPath(cli.dataDir)
+	store, err := blockchain.OpenBadgerStore(dbPath)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	chain, count, err := blockchain.ImportChain(store, params, file)
+	if err != nil {
+		store.Close()
+		os.RemoveAll(dbPath)
+		fmt.Printf("Import failed: %s\n", err)
+		runtime.Goexit()
+	}
+	chain.Database.Close()
+	fmt.Printf("Imported %d blocks from %s\n", count, path)
+}
+
 func (cli *CommandLine) listaddresses() {
-	wallets, _ := wallet.CreateWallets()
+	wallets, _ := wallet.CreateWallets(cli.dataDir)
 	addresses := wallets.GetAllAddresses()
 
 	for _, address := range addresses {
@@ -115,7 +660,7 @@ func (cli *CommandLine) listaddresses() {
 }
 
 func (cli *CommandLine) createWallet() {
-	wallets, _ := wallet.CreateWallets()
+	wallets, _ := wallet.CreateWallets(cli.dataDir)
 	address := wallets.AddWallet()
 	wallets.SaveFile()
 
@@ -123,7 +668,53 @@ func (cli *CommandLine) createWallet() {
 }
 
 func (cli *CommandLine) run() {
-	cli.validateArgs()
+	globalCmd := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	globalCmd.Usage = cli.printUsage
+
+	defaultDataDir := os.Getenv(dataDirEnv)
+	if defaultDataDir == "" {
+		defaultDataDir = "./tmp"
+	}
+	dataDir := globalCmd.String("datadir", defaultDataDir, "Directory holding the chain and wallets")
+	prune := globalCmd.Int("prune", 0, "Keep transactions only for the last N blocks")
+	paramsFile := globalCmd.String("params", "", "JSON file with the parameters of the network to use")
+	regtest := globalCmd.Bool("regtest", false, "Use the local test network")
+	checkpoints := globalCmd.String("checkpoints", "", "Comma-separated HEIGHT:HASH blocks the chain must contain")
+
+	err := globalCmd.Parse(os.Args[1:])
+	if err != nil {
+		log.Panic(err)
+	}
+	args := globalCmd.Args()
+	cli.validateArgs(args)
+	cli.dataDir = *dataDir
+	cli.prune = *prune
+	if cli.prune < 0 {
+		cli.printUsage()
+		runtime.Goexit()
+	}
+	if *regtest && *paramsFile != "" {
+		cli.printUsage()
+		runtime.Goexit()
+	}
+	cli.params = &blockchain.MainParams
+	if *regtest {
+		cli.params = &blockchain.RegtestParams
+	}
+	if *paramsFile != "" {
+		cli.params, err = blockchain.LoadChainParams(*paramsFile)
+		if err != nil {
+			fmt.Println(err)
+			runtime.Goexit()
+		}
+	}
+	if *checkpoints != "" {
+		cli.addCheckpoints(*checkpoints)
+	}
+	if cli.params.Name != blockchain.MainParams.Name {
+		cli.dataDir = filepath.Join(cli.dataDir, cli.params.Name)
+	}
+	wallet.Version = cli.params.AddressVersion
 
 	getBalanceCmd := flag.NewFlagSet("getbalance", flag.ExitOnError)
 	createBlockchainCmd := flag.NewFlagSet("createblockchain", flag.ExitOnError)
@@ -131,41 +722,161 @@ func (cli *CommandLine) run() {
 	printChainCmd := flag.NewFlagSet("printchain", flag.ExitOnError)
 	createWalletCmd := flag.NewFlagSet("createwallet", flag.ExitOnError)
 	listAddressesCmd := flag.NewFlagSet("listaddresses", flag.ExitOnError)
+	reindexUTXOCmd := flag.NewFlagSet("reindexutxo", flag.ExitOnError)
+	getBlockCmd := flag.NewFlagSet("getblock", flag.ExitOnError)
+	getBlockCountCmd := flag.NewFlagSet("getblockcount", flag.ExitOnError)
+	reindexTxCmd := flag.NewFlagSet("reindextx", flag.ExitOnError)
+	getTransactionCmd := flag.NewFlagSet("gettransaction", flag.ExitOnError)
+	historyCmd := flag.NewFlagSet("history", flag.ExitOnError)
+	verifyChainCmd := flag.NewFlagSet("verifychain", flag.ExitOnError)
+	exportChainCmd := flag.NewFlagSet("exportchain", flag.ExitOnError)
+	migrateCmd := flag.NewFlagSet("migrate", flag.ExitOnError)
+	importChainCmd := flag.NewFlagSet("importchain", flag.ExitOnError)
+	getTxProofCmd := flag.NewFlagSet("gettxproof", flag.ExitOnError)
+	verifyTxProofCmd := flag.NewFlagSet("verifytxproof", flag.ExitOnError)
+	getChainTipsCmd := flag.NewFlagSet("getchaintips", flag.ExitOnError)
+	stakeCmd := flag.NewFlagSet("stake", flag.ExitOnError)
+	generateCmd := flag.NewFlagSet("generate", flag.ExitOnError)
 
 	getBalanceAddress := getBalanceCmd.String("address", "", "The address to get balance for")
 	createBlockchainAddress := createBlockchainCmd.String("address", "", "The address to send genesis block reward to")
+	createBlockchainTxIndex := createBlockchainCmd.Bool("txindex", false, "Maintain a transaction index")
+	createBlockchainThreads := createBlockchainCmd.Int("threads", 0, "Number of mining threads (0 for one per CPU)")
+	createBlockchainHalving := createBlockchainCmd.Int("halving", cli.params.Rewards.HalvingInterval, "Number of blocks between subsidy halvings (0 for never)")
+	consensus := cli.params.Consensus
+	if consensus == "" {
+		consensus = "pow"
+	}
+	authorities := strings.Join(cli.params.Authorities, ",")
+	createBlockchainConsensus := createBlockchainCmd.String("consensus", consensus, "Consensus engine: pow (proof of work), poa (proof of authority) or pos (proof of stake)")
+	createBlockchainAuthorities := createBlockchainCmd.String("authorities", authorities, "Comma-separated addresses taking turns to sign blocks, with -consensus poa")
+	createBlockchainMaxSupply := createBlockchainCmd.Int("maxsupply", cli.params.Rewards.MaxSupply, "Total number of coins that can ever be minted")
 	sendFrom := sendCmd.String("from", "", "Source wallet address")
 	sendTo := sendCmd.String("to", "", "Destination wallet address")
 	sendAmount := sendCmd.Int("amount", 0, "Amount to send")
+	sendFee := sendCmd.Int("fee", 0, "Fee left to the miner of the block")
+	sendThreads := sendCmd.Int("threads", 0, "Number of mining threads (0 for one per CPU)")
+	stakeAddress := stakeCmd.String("address", "", "The address whose outputs to stake")
+	generateBlocks := generateCmd.Int("blocks", 1, "Number of blocks to mine")
+	generateAddress := generateCmd.String("address", "", "The address to send the block rewards to")
+	getBlockHeight := getBlockCmd.Int("height", -1, "Height of the block")
+	getBlockHash := getBlockCmd.String("hash", "", "Hash of the block")
+	getTransactionID := getTransactionCmd.String("id", "", "ID of the transaction")
+	historyAddress := historyCmd.String("address", "", "The address to list transactions for")
+	historySkip := historyCmd.Int("skip", 0, "Number of most recent transactions to skip")
+	historyCount := historyCmd.Int("count", 20, "Maximum number of transactions to list (0 for all)")
+	verifyChainLevel := verifyChainCmd.Int("level", blockchain.VerifyUTXO, "How thorough the checks are (0-3)")
+	exportChainFile := exportChainCmd.String("file", "", "Bootstrap file to write")
+	importChainFile := importChainCmd.String("file", "", "Bootstrap file to read")
+	importChainConsensus := importChainCmd.String("consensus", consensus, "Consensus engine of the network, when there is no chain yet")
+	importChainAuthorities := importChainCmd.String("authorities", authorities, "Comma-separated authorities of the network, with -consensus poa")
+	getTxProofID := getTxProofCmd.String("id", "", "ID of the transaction")
+	verifyTxProofProof := verifyTxProofCmd.String("proof", "", "Proof printed by gettxproof")
+	migrateDryRun := migrateCmd.Bool("dry-run", false, "Report the pending migrations without applying them")
 
-	switch os.Args[1] {
+	switch args[0] {
 	case "getbalance":
-		err := getBalanceCmd.Parse(os.Args[2:])
+		err := getBalanceCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "createblockchain":
-		err := createBlockchainCmd.Parse(os.Args[2:])
+		err := createBlockchainCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "listaddresses":
-		err := listAddressesCmd.Parse(os.Args[2:])
+		err := listAddressesCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "createwallet":
-		err := createWalletCmd.Parse(os.Args[2:])
+		err := createWalletCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "printchain":
-		err := printChainCmd.Parse(os.Args[2:])
+		err := printChainCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
 	case "send":
-		err := sendCmd.Parse(os.Args[2:])
+		err := sendCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "reindexutxo":
+		err := reindexUTXOCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getblock":
+		err := getBlockCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getblockcount":
+		err := getBlockCountCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "reindextx":
+		err := reindexTxCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "gettransaction":
+		err := getTransactionCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "history":
+		err := historyCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "verifychain":
+		err := verifyChainCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "exportchain":
+		err := exportChainCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "migrate":
+		err := migrateCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "importchain":
+		err := importChainCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "gettxproof":
+		err := getTxProofCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "verifytxproof":
+		err := verifyTxProofCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "getchaintips":
+		err := getChainTipsCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "stake":
+		err := stakeCmd.Parse(args[1:])
+		if err != nil {
+			log.Panic(err)
+		}
+	case "generate":
+		err := generateCmd.Parse(args[1:])
 		if err != nil {
 			log.Panic(err)
 		}
@@ -183,11 +894,14 @@ func (cli *CommandLine) run() {
 	}
 
 	if createBlockchainCmd.Parsed() {
-		if *createBlockchainAddress == "" {
+		if *createBlockchainAddress == "" || *createBlockchainThreads < 0 {
 			createBlockchainCmd.Usage()
 			runtime.Goexit()
 		}
-		cli.createBlockChain(*createBlockchainAddress)
+		params := withConsensus(*cli.params, *createBlockchainConsensus, *createBlockchainAuthorities)
+		params.Rewards.HalvingInterval = *createBlockchainHalving
+		params.Rewards.MaxSupply = *createBlockchainMaxSupply
+		cli.createBlockChain(*createBlockchainAddress, *createBlockchainTxIndex, *createBlockchainThreads, params)
 	}
 
 	if printChainCmd.Parsed() {
@@ -201,13 +915,113 @@ func (cli *CommandLine) run() {
 		cli.listaddresses()
 	}
 
+	if reindexUTXOCmd.Parsed() {
+		cli.reindexUTXO()
+	}
+
+	if getBlockCmd.Parsed() {
+		if (*getBlockHeight < 0) == (*getBlockHash == "") {
+			getBlockCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.getBlock(*getBlockHeight, *getBlockHash)
+	}
+
+	if getBlockCountCmd.Parsed() {
+		cli.getBlockCount()
+	}
+
+	if reindexTxCmd.Parsed() {
+		cli.reindexTx()
+	}
+
+	if getTransactionCmd.Parsed() {
+		if *getTransactionID == "" {
+			getTransactionCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.getTransaction(*getTransactionID)
+	}
+
+	if getTxProofCmd.Parsed() {
+		if *getTxProofID == "" {
+			getTxProofCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.getTxProof(*getTxProofID)
+	}
+
+	if verifyTxProofCmd.Parsed() {
+		if *verifyTxProofProof == "" {
+			verifyTxProofCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.verifyTxProof(*verifyTxProofProof)
+	}
+
+	if getChainTipsCmd.Parsed() {
+		cli.getChainTips()
+	}
+
+	if historyCmd.Parsed() {
+		if *historyAddress == "" || *historySkip < 0 || *historyCount < 0 {
+			historyCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.history(*historyAddress, *historySkip, *historyCount)
+	}
+
+	if verifyChainCmd.Parsed() {
+		if *verifyChainLevel < blockchain.VerifyHeaders || *verifyChainLevel > blockchain.VerifyUTXO {
+			verifyChainCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.verifyChain(*verifyChainLevel)
+	}
+
+	if exportChainCmd.Parsed() {
+		if *exportChainFile == "" {
+			exportChainCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.exportChain(*exportChainFile)
+	}
+
+	if importChainCmd.Parsed() {
+		if *importChainFile == "" {
+			importChainCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.importChain(*importChainFile, withConsensus(*cli.params, *importChainConsensus, *importChainAuthorities))
+	}
+
+	if migrateCmd.Parsed() {
+		cli.migrate(*migrateDryRun)
+	}
+
 	if sendCmd.Parsed() {
-		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 {
+		if *sendFrom == "" || *sendTo == "" || *sendAmount <= 0 || *sendFee < 0 || *sendThreads < 0 {
 			sendCmd.Usage()
 			runtime.Goexit()
 		}
 
-		cli.send(*sendFrom, *sendTo, *sendAmount)
+		cli.send(*sendFrom, *sendTo, *sendAmount, *sendFee, *sendThreads)
+	}
+
+	if stakeCmd.Parsed() {
+		if *stakeAddress == "" {
+			stakeCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.stake(*stakeAddress)
+	}
+
+	if generateCmd.Parsed() {
+		if *generateAddress == "" || *generateBlocks <= 0 {
+			generateCmd.Usage()
+			runtime.Goexit()
+		}
+		cli.generate(*generateBlocks, *generateAddress)
 	}
 }
 func main() {
@@ -11,10 +11,12 @@ import (
 	"golang.org/x/crypto/ripemd160"
 )
 
-const (
-	checksumLength = 4
-	version        = byte(0x00)
-)
+const checksumLength = 4
+
+// Version is the first byte of every address, which keeps addresses of
+// different networks apart. Programs using another network set it to that
+// network's address version before creating or checking addresses.
+var Version = byte(0x00)
 
 type Wallet struct {
 	PrivateKey ecdsa.PrivateKey
@@ -22,9 +24,11 @@ type Wallet struct {
 }
 
 func (wallet Wallet) Address() []byte {
-	pubHash := PublicKeyHash(wallet.PublicKey)
+	return PubKeyHashToAddress(PublicKeyHash(wallet.PublicKey))
+}
 
-	versionedHash := append([]byte{version}, pubHash...)
+func PubKeyHashToAddress(pubHash []byte) []byte {
+	versionedHash := append([]byte{Version}, pubHash...)
 	checksum := Checksum(versionedHash)
 
 	fullHash := append(versionedHash, checksum...)
@@ -41,7 +45,9 @@ func CreateNewKeyPair() (ecdsa.PrivateKey, []byte) {
 		log.Panic(err)
 	}
 
-	pub := append(private.PublicKey.X.Bytes(), private.PublicKey.Y.Bytes()...)
+	pub := make([]byte, 64)
+	private.PublicKey.X.FillBytes(pub[:32])
+	private.PublicKey.Y.FillBytes(pub[32:])
 	return *private, pub
 }
 
@@ -72,12 +78,21 @@ func Checksum(payload []byte) []byte {
 	return secondHash[:checksumLength]
 }
 
+// ValidateAddress checks the checksum of address and that it belongs to the
+// network in use.
 func ValidateAddress(address string) bool {
+	return ValidateAddressVersion(address, Version)
+}
+
+// ValidateAddressVersion is ValidateAddress for the network whose addresses
+// start with version.
+func ValidateAddressVersion(address string, version byte) bool {
 	pubKeyHash := Base58Decode([]byte(address))
+	if len(pubKeyHash) <= checksumLength {
+		return false
+	}
 	actualChecksum := pubKeyHash[len(pubKeyHash)-checksumLength:]
-	version := pubKeyHash[0]
-	pubKeyHash = pubKeyHash[1 : len(pubKeyHash)-checksumLength]
-	targetChecksum := Checksum(append([]byte{version}, pubKeyHash...))
+	targetChecksum := Checksum(pubKeyHash[:len(pubKeyHash)-checksumLength])
 
-	return bytes.Compare(actualChecksum, targetChecksum) == 0
+	return pubKeyHash[0] == version && bytes.Compare(actualChecksum, targetChecksum) == 0
 }
@@ -7,17 +7,20 @@ import (
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 )
 
-const walletFile = "./tmp/wallets.data"
+const walletFile = "wallets.data"
 
 type Wallets struct {
 	Wallets map[string]*Wallet
+	dataDir string
 }
 
-func CreateWallets() (*Wallets, error) {
+func CreateWallets(dataDir string) (*Wallets, error) {
 	wallets := Wallets{}
 	wallets.Wallets = make(map[string]*Wallet)
+	wallets.dataDir = dataDir
 	err := wallets.LoadFile()
 	return &wallets, err
 }
@@ -43,13 +46,17 @@ func (wallets *Wallets) GetAllAddresses() []string {
 	return addresses
 }
 
+func (wallets *Wallets) filePath() string {
+	return filepath.Join(wallets.dataDir, walletFile)
+}
+
 func (wallets *Wallets) LoadFile() error {
-	if _, err := os.Stat(walletFile); os.IsNotExist(err) {
+	if _, err := os.Stat(wallets.filePath()); os.IsNotExist(err) {
 		return err
 	}
 
 	var loaded_wallets Wallets
-	fileContent, err := ioutil.ReadFile(walletFile)
+	fileContent, err := ioutil.ReadFile(wallets.filePath())
 	if err != nil {
 		log.Panic(err)
 	}
@@ -77,7 +84,12 @@ func (wallets *Wallets) SaveFile() {
 		log.Panic(err)
 	}
 
-	err = ioutil.WriteFile(walletFile, content.Bytes(), 0644)
+	err = os.MkdirAll(wallets.dataDir, 0755)
+	if err != nil {
+		log.Panic(err)
+	}
+
+	err = ioutil.WriteFile(wallets.filePath(), content.Bytes(), 0644)
 	if err != nil {
 		log.Panic(err)
 	}